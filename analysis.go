@@ -0,0 +1,288 @@
+// Sliding window analysis and the game clock.
+//
+// The pitch is detected on overlapping windows of the audio, a new window
+// starts every hop samples, so no part of the audio is left out and short
+// notes are seen. The game clock is independent of that cadence, it moves
+// the score one column every game step and decides the note played in the
+// step from all the windows that ended inside it.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Default length and hop of the analysis windows, in samples of the audio input.
+const DEFAULT_WINDOW_LEN int = 2048
+const DEFAULT_HOP_LEN int = 512
+
+// Duration of a game step, the time the score takes to move one column.
+const GAME_STEP_SECONDS float64 = 15000.0 / 44100
+
+// A note tongued this long before its step still counts as tongued, the
+// player rarely starts a note exactly when its column reaches the line.
+const TONGUE_EARLY_SECONDS float64 = 0.15
+
+// Settings of the analysis, from the command line.
+type AnalysisOptions struct {
+	WindowLen           int           // Samples in each analysis window.
+	HopLen              int           // Samples between the start of two windows.
+	GateThresholdDB     float64       // RMS level in dBFS below which the input is silence.
+	GateAttack          time.Duration // Time above the threshold to open the gate.
+	GateRelease         time.Duration // Time below the threshold to close the gate.
+	InputLatency        time.Duration // Time from playing to detecting a note, the judgement is shifted by it.
+	PitchDetector       string        // Name of the pitch detection algorithm, see newPitchDetector.
+	MinConfidence       float64       // Frames less confident than this are UNCERTAIN.
+	MedianFrames        int           // Frames of the median filter of the note tracker.
+	NoteHysteresisCents float64       // How much closer another note must be to replace the current one.
+	MinNoteDuration     time.Duration // Shortest note the note tracker accepts.
+	StepSeconds         float64       // Duration of a step, 0 for the GAME_STEP_SECONDS of the game.
+}
+
+// Samples in one game step at a sample rate.
+func gameStepLenAt(sampleRate float64) int {
+	return int(math.Round(GAME_STEP_SECONDS * sampleRate))
+}
+
+// Checks the window and hop lengths given in the command line.
+func validateAnalysisWindow(windowLen int, hopLen int) error {
+	if windowLen < 64 {
+		return fmt.Errorf("the analysis window must have at least 64 samples, it has %d", windowLen)
+	}
+	if hopLen < 1 || hopLen > windowLen {
+		return fmt.Errorf("the hop must be between 1 and the window length %d, it is %d", windowLen, hopLen)
+	}
+	return nil
+}
+
+// Checks the minimum confidence given in the command line.
+func validateMinConfidence(minConfidence float64) error {
+	if minConfidence < 0 || minConfidence > 1 {
+		return fmt.Errorf("the minimum confidence must be between 0 and 1, it is %g", minConfidence)
+	}
+	return nil
+}
+
+// Checks that the window can hold the lowest note of the flute, once the sample rate is known.
+func validateWindowForRange(windowLen int, sampleRate float64) error {
+	minFrequency, _ := musicNote.MNFrequencyRange()
+	if minWindowLen := yinMinWindowLen(sampleRate, minFrequency); windowLen < minWindowLen {
+		return fmt.Errorf("the analysis window of %d samples is too short for %.0f Hz at %.0f Hz, it must have at least %d",
+			windowLen, minFrequency, sampleRate, minWindowLen)
+	}
+	return nil
+}
+
+// Pitch detected in one analysis window.
+type PitchFrame struct {
+	sampleTime        int64   // Index in the audio of the sample after the end of the window.
+	frequency         float64 // Frequency in Hz after the octave correction, -1 if none was found.
+	detectedFrequency float64 // Frequency in Hz the detector found, before the octave correction.
+	confidence        float64 // Certainty of the frequency, from 0 to 1.
+	clarity           float64 // How periodic the window is, from 0 to 1.
+	rmsDB             float64 // RMS level of the window in dBFS.
+	peakDB            float64 // Peak level of the window in dBFS.
+	silent            bool    // The noise gate was closed, the window wasn't analysed.
+	onset             bool    // A note started near the end of the window.
+	onsetTime         int64   // Estimated sample time of the start of the note, with onset.
+	rawNote           int     // Note of this frame alone, see classifyPitchFrame.
+	note              int     // Stable note from the note tracker.
+	smoothedFrequency float64 // Median frequency of the last frames, -1 without a clear pitch.
+	squeak            bool    // The window is an overblown squeak, see octave.go.
+}
+
+//##################
+// Sliding window analyzer.
+
+type slidingWindowAnalyzer struct {
+	sampleRate  float64
+	history     []float32 // Circular buffer with the last window of samples.
+	historyPos  int       // Where the next sample goes in history.
+	window      []float32 // The window in time order, given to the detector.
+	hopLen      int
+	sinceHop    int   // Samples since the last analysis.
+	samplesSeen int64 // Total samples received.
+	gate        *noiseGate
+	detector    PitchDetector
+	onsets      *onsetDetector
+	octaves     *octaveCorrector
+	tracker     *noteTracker
+}
+
+func newSlidingWindowAnalyzer(sampleRate float64, options AnalysisOptions) *slidingWindowAnalyzer {
+	minFrequency, maxFrequency := musicNote.MNFrequencyRange()
+	detector, err := newPitchDetector(options.PitchDetector, options.WindowLen, sampleRate, minFrequency, maxFrequency)
+	chk(err)
+	return &slidingWindowAnalyzer{
+		sampleRate: sampleRate,
+		history:    make([]float32, options.WindowLen),
+		window:     make([]float32, options.WindowLen),
+		hopLen:     options.HopLen,
+		gate:       newNoiseGate(options.GateThresholdDB, options.GateAttack, options.GateRelease),
+		detector:   detector,
+		onsets:     newOnsetDetector(sampleRate, options.HopLen),
+		octaves:    newOctaveCorrector(options.WindowLen, sampleRate, minFrequency, maxFrequency),
+		tracker:    newNoteTracker(options, sampleRate),
+	}
+}
+
+// Adds one sample, returns true with a PitchFrame when a window is complete.
+func (A *slidingWindowAnalyzer) Push(sample float32) (PitchFrame, bool) {
+	A.history[A.historyPos] = sample
+	A.historyPos = (A.historyPos + 1) % len(A.history)
+	A.samplesSeen++
+	A.sinceHop++
+
+	// Waits for the first window to be full, then analyses every hop.
+	if A.samplesSeen < int64(len(A.history)) || A.sinceHop < A.hopLen {
+		return PitchFrame{}, false
+	}
+	A.sinceHop = 0
+
+	n := copy(A.window, A.history[A.historyPos:])
+	copy(A.window[n:], A.history[:A.historyPos])
+	frame := PitchFrame{sampleTime: A.samplesSeen, frequency: -1, detectedFrequency: -1, confidence: 0, clarity: 0}
+	frame.rmsDB, frame.peakDB = measureLevel(A.window)
+	frame.silent = !A.gate.Process(frame.rmsDB, float64(A.hopLen)/A.sampleRate)
+	if frame.onset = A.onsets.Process(A.window, A.samplesSeen, frame.silent); frame.onset {
+		frame.onsetTime = A.onsets.OnsetTime(A.samplesSeen)
+	}
+	if !frame.silent {
+		estimate := A.detector.DetectPitch(A.window)
+		frame.detectedFrequency, frame.confidence, frame.clarity = estimate.frequency, estimate.confidence, estimate.clarity
+		frame.frequency, frame.squeak = A.octaves.Correct(A.window, estimate.frequency, A.tracker.CurrentFrequency())
+	}
+	A.tracker.Track(&frame)
+	return frame, true
+}
+
+//##################
+// Game clock.
+
+type gameClock struct {
+	stepLen       int
+	earlyLen      int          // Samples of TONGUE_EARLY_SECONDS.
+	sinceStep     int          // Samples since the start of the step, negative while waiting for the latency.
+	samplesSeen   int64        // Total samples counted.
+	frames        []PitchFrame // Frames that ended during the step.
+	lastOnsetTime int64        // Sample time of the last onset, -1 if none yet.
+}
+
+// The steps end latency later in the audio, so each step is judged with what
+// the player played while its column was under the score line.
+func newGameClock(sampleRate float64, stepSeconds float64, latency time.Duration) *gameClock {
+	return &gameClock{
+		stepLen:       int(math.Round(stepSeconds * sampleRate)),
+		earlyLen:      int(math.Round(TONGUE_EARLY_SECONDS * sampleRate)),
+		sinceStep:     -int(math.Round(latency.Seconds() * sampleRate)),
+		lastOnsetTime: -1,
+	}
+}
+
+func (C *gameClock) AddPitchFrame(frame PitchFrame) {
+	C.frames = append(C.frames, frame)
+}
+
+// Counts one sample, returns true with the AnalysisFrame of the step when it ends.
+func (C *gameClock) Tick() (AnalysisFrame, bool) {
+	C.sinceStep++
+	C.samplesSeen++
+	if C.sinceStep < C.stepLen {
+		return AnalysisFrame{}, false
+	}
+	C.sinceStep = 0
+	step := summarizeStep(C.frames)
+	step.sampleTime = C.samplesSeen
+	if len(step.onsetTimes) > 0 {
+		C.lastOnsetTime = step.onsetTimes[len(step.onsetTimes)-1]
+	}
+	step.tongued = C.lastOnsetTime >= 0 && C.lastOnsetTime >= C.samplesSeen-int64(C.stepLen+C.earlyLen)
+	C.frames = C.frames[:0]
+	return step, true
+}
+
+// Returns the flute note of a frame, SILENCE when the noise gate was closed
+// and UNCERTAIN when there is sound, but no pitch the detector is sure of.
+// SQUEAK when the flute was overblown. A frequency too far from every note,
+// NOTE_UNKNOWN, is UNCERTAIN too.
+func classifyPitchFrame(frame PitchFrame, minConfidence float64) int {
+	if frame.silent {
+		return SILENCE
+	}
+	if frame.squeak {
+		return SQUEAK
+	}
+	if frame.frequency < 0 || frame.confidence < minConfidence {
+		return UNCERTAIN
+	}
+	match := musicNote.MNMatchNote(frame.frequency)
+	if match.status == NOTE_UNKNOWN {
+		return UNCERTAIN
+	}
+	return match.note
+}
+
+// The note played in a step is the tracked note, a flute note, the silence or
+// UNCERTAIN, of most of its frames, with the median frequency, confidence and
+// clarity of those frames. The levels are the loudest of the step and the onsets are all
+// the ones found in it.
+func summarizeStep(frames []PitchFrame) AnalysisFrame {
+	step := AnalysisFrame{note: SILENCE, frequency: -1, confidence: 0, clarity: 0, rmsDB: LEVEL_FLOOR_DB, peakDB: LEVEL_FLOOR_DB, silent: true}
+	if len(frames) == 0 {
+		return step
+	}
+
+	votes := make(map[int]int)
+	bestNote := SILENCE
+	for i := len(frames) - 1; i >= 0; i-- {
+		// From the last to the first, so a tie goes to the most recent note.
+		note := frames[i].note
+		votes[note]++
+		if votes[note] > votes[bestNote] {
+			bestNote = note
+		}
+		step.rmsDB = math.Max(step.rmsDB, frames[i].rmsDB)
+		step.peakDB = math.Max(step.peakDB, frames[i].peakDB)
+	}
+	for _, frame := range frames {
+		if frame.onset {
+			step.onsetTimes = append(step.onsetTimes, frame.onsetTime)
+		}
+	}
+	if bestNote == SILENCE {
+		return step
+	}
+
+	frequencies := []float64{}
+	confidences := []float64{}
+	clarities := []float64{}
+	for _, frame := range frames {
+		// The frames the tracker held on the note, but that alone had no pitch, don't count.
+		if frame.note == bestNote && frame.smoothedFrequency > 0 {
+			frequencies = append(frequencies, frame.smoothedFrequency)
+			confidences = append(confidences, frame.confidence)
+			clarities = append(clarities, frame.clarity)
+		}
+	}
+	if len(frequencies) > 0 {
+		step.frequency = median(frequencies)
+		step.confidence = median(confidences)
+		step.clarity = median(clarities)
+	}
+	step.silent = false
+	step.note = bestNote
+	return step
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
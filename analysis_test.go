@@ -0,0 +1,92 @@
+// Tests of the notes given to the frames and to the game steps.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestClassifyPitchFrame(t *testing.T) {
+	musicNote.MNnew()
+	at := func(note int, cents float64) float64 {
+		return musicNote.frequency[note] * math.Pow(2, cents/1200)
+	}
+	tests := []struct {
+		name  string
+		frame PitchFrame
+		want  int
+	}{
+		{"silent", PitchFrame{silent: true, frequency: -1}, SILENCE},
+		{"silent squeak", PitchFrame{silent: true, squeak: true, frequency: -1}, SILENCE},
+		{"squeak", PitchFrame{squeak: true, frequency: at(LA, 0), confidence: 1}, SQUEAK},
+		{"no pitch", PitchFrame{frequency: -1, confidence: 1}, UNCERTAIN},
+		{"not confident", PitchFrame{frequency: at(LA, 0), confidence: 0.79}, UNCERTAIN},
+		{"just confident", PitchFrame{frequency: at(LA, 0), confidence: 0.8}, LA},
+		{"in tune", PitchFrame{frequency: at(SOL, 0), confidence: 1}, SOL},
+		{"sharp", PitchFrame{frequency: at(LA, 40), confidence: 1}, LA},
+		{"flat", PitchFrame{frequency: at(LA, -40), confidence: 1}, LA},
+		{"between two notes", PitchFrame{frequency: at(LA, 60), confidence: 1}, UNCERTAIN},
+		{"nearer the next note", PitchFrame{frequency: at(MI, 60), confidence: 1}, FA},
+		{"below the flute", PitchFrame{frequency: at(DO, -200), confidence: 1}, UNCERTAIN},
+	}
+	for _, test := range tests {
+		if got := classifyPitchFrame(test.frame, 0.8); got != test.want {
+			t.Errorf("%s: %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+// A frame of the step with the tracked note and its smoothed frequency.
+func trackedFrame(note int, frequency float64) PitchFrame {
+	return PitchFrame{note: note, smoothedFrequency: frequency, confidence: 0.9, clarity: 0.8, rmsDB: -20, peakDB: -14}
+}
+
+func TestSummarizeStep(t *testing.T) {
+	onset := trackedFrame(LA, 880)
+	onset.onset, onset.onsetTime = true, 1000
+	loud := trackedFrame(SILENCE, -1)
+	loud.rmsDB, loud.peakDB = -10, -3
+	tests := []struct {
+		name       string
+		frames     []PitchFrame
+		note       int
+		frequency  float64
+		confidence float64
+		silent     bool
+		rmsDB      float64
+		onsets     int
+	}{
+		{"no frames", nil, SILENCE, -1, 0, true, LEVEL_FLOOR_DB, 0},
+		{"silence", []PitchFrame{trackedFrame(SILENCE, -1), trackedFrame(SILENCE, -1)}, SILENCE, -1, 0, true, -20, 0},
+		{"most of the step", []PitchFrame{trackedFrame(SOL, 784), trackedFrame(LA, 880), trackedFrame(LA, 884), trackedFrame(LA, 882)},
+			LA, 882, 0.9, false, -20, 0},
+		{"median of an even count", []PitchFrame{trackedFrame(LA, 880), trackedFrame(LA, 886), trackedFrame(LA, 882), trackedFrame(LA, 884)},
+			LA, 883, 0.9, false, -20, 0},
+		{"tie goes to the last note", []PitchFrame{trackedFrame(SOL, 784), trackedFrame(SOL, 784), trackedFrame(LA, 880), trackedFrame(LA, 880)},
+			LA, 880, 0.9, false, -20, 0},
+		{"tie goes to the last note, alternating", []PitchFrame{trackedFrame(LA, 880), trackedFrame(SOL, 784), trackedFrame(LA, 880), trackedFrame(SOL, 784)},
+			SOL, 784, 0.9, false, -20, 0},
+		{"tie with the silence", []PitchFrame{trackedFrame(LA, 880), trackedFrame(SILENCE, -1)}, SILENCE, -1, 0, true, -20, 0},
+		{"held without a pitch", []PitchFrame{trackedFrame(LA, -1), trackedFrame(LA, -1), trackedFrame(SOL, 784)},
+			LA, -1, 0, false, -20, 0},
+		{"some frames without a pitch", []PitchFrame{trackedFrame(LA, -1), trackedFrame(LA, 890), trackedFrame(LA, -1)},
+			LA, 890, 0.9, false, -20, 0},
+		{"uncertain", []PitchFrame{trackedFrame(UNCERTAIN, 920), trackedFrame(UNCERTAIN, 922), trackedFrame(LA, 880)},
+			UNCERTAIN, 921, 0.9, false, -20, 0},
+		{"onsets and the loudest level", []PitchFrame{onset, loud, trackedFrame(LA, 880), onset},
+			LA, 880, 0.9, false, -10, 2},
+	}
+	for _, test := range tests {
+		step := summarizeStep(test.frames)
+		if step.note != test.note || step.silent != test.silent {
+			t.Errorf("%s: note %d silent %v, want %d and %v", test.name, step.note, step.silent, test.note, test.silent)
+		}
+		if math.Abs(step.frequency-test.frequency) > 1e-9 || step.confidence != test.confidence {
+			t.Errorf("%s: %g Hz confidence %g, want %g Hz and %g", test.name, step.frequency, step.confidence, test.frequency, test.confidence)
+		}
+		if step.rmsDB != test.rmsDB || len(step.onsetTimes) != test.onsets {
+			t.Errorf("%s: %g dB and %d onsets, want %g dB and %d", test.name, step.rmsDB, len(step.onsetTimes), test.rmsDB, test.onsets)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Audio sources.
+//
+// The game doesn't care where the audio comes from, it only needs blocks of
+// samples. An AudioSource delivers interleaved float32 frames to a handler
+// function, the same way the portaudio callback does for the microphone.
+// Besides the microphone there are sources for WAV files, raw PCM on stdin
+// and a synthetic tone generator, so the game can run without a sound card.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Receives a block of interleaved samples, Channels() samples per frame.
+type AudioFrameHandler func(in []float32)
+
+type AudioSource interface {
+	Start(handler AudioFrameHandler) error // Starts delivering frames to the handler.
+	Stop() error                           // Stops delivering frames.
+	Close() error                          // Releases the resources of the source.
+	SampleRate() float64                   // Frames per second.
+	Channels() int                         // Number of interleaved channels in each frame.
+	Done() <-chan struct{}                 // Closed when the source has no more audio.
+	Err() error                            // Error that ended the audio, nil at its end, once Done is closed.
+	Live() bool                            // True when the audio can't wait for the game.
+}
+
+// Settings of the audio input, from the command line flags.
+type AudioInputOptions struct {
+	Input         string  // See openAudioSource.
+	Device        string  // Microphone device, see findInputDevice.
+	Monitor       bool    // Plays the microphone in the output.
+	MonitorGain   float64 // Gain of the microphone in the output.
+	Speed         float64 // Replay speed of the non live sources, 1 is real time and 0 is as fast as possible.
+	PCMFormat     string  // Sample format of the raw PCM on stdin, PCM_FORMAT_S16LE or PCM_FORMAT_F32LE.
+	PCMSampleRate int     // Sample rate of the raw PCM on stdin.
+	PCMChannels   int     // Number of interleaved channels of the raw PCM on stdin.
+	SynthVibrato  float64 // Vibrato depth in cents of the synthetic recorder.
+	SynthDetune   float64 // Detune in cents of the synthetic recorder.
+	SynthRate     int     // Sample rate of the synthetic recorder and of the tone.
+}
+
+// Opens the audio source named in the --input flag.
+//
+//	""           the microphone (portaudio must be initialized).
+//	"-"          raw little endian PCM on stdin, in the format of the --pcm-* flags.
+//	"tone:<Hz>"  a synthetic sine tone at that frequency.
+//	"synth"      a synthetic recorder playing the music score (gameInit must be done).
+//	anything     else is the path of a WAV file.
+func openAudioSource(options AudioInputOptions) (AudioSource, error) {
+	input := options.Input
+	switch {
+	case input == "":
+		return newMicophone(time.Second/3, options.Device, options.Monitor, options.MonitorGain)
+
+	case input == "-":
+		return newRawPCMSource(os.Stdin, options.PCMFormat, options.PCMSampleRate, options.PCMChannels, options.Speed)
+
+	case strings.HasPrefix(input, "tone:"):
+		frequency, err := strconv.ParseFloat(strings.TrimPrefix(input, "tone:"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tone frequency in %q", input)
+		}
+		return newToneSource(frequency, options.SynthRate, options.Speed), nil
+
+	case input == "synth":
+		return newRecorderSynthSource(&music_01, &musicNote, options.SynthRate, options.SynthVibrato, options.SynthDetune, options.Speed), nil
+
+	default:
+		return newWavFileSource(input, options.Speed)
+	}
+}
+
+//##################
+// Paced delivery of blocks of samples for the non live sources.
+
+// Number of frames in each block, the size of a typical portaudio callback.
+const SOURCE_BLOCK_LEN int = 512
+
+type pacedFeeder struct {
+	sampleRate float64
+	channels   int
+	speed      float64 // 1.0 is real time, 0 is as fast as possible.
+	err        error   // Error of read, set before done is closed.
+	stop       chan struct{}
+	done       chan struct{}
+	stopOnce   sync.Once
+	doneOnce   sync.Once
+}
+
+func newPacedFeeder(sampleRate float64, channels int, speed float64) pacedFeeder {
+	return pacedFeeder{
+		sampleRate: sampleRate,
+		channels:   channels,
+		speed:      speed,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Calls read to fill each block and hands it to the handler, until read
+// returns no frames or the feeder is stopped. read returns the number of
+// frames it wrote into the block, and sets err when it ended with an error.
+func (F *pacedFeeder) run(handler AudioFrameHandler, read func(block []float32) int) {
+	defer F.doneOnce.Do(func() { close(F.done) })
+
+	block := make([]float32, SOURCE_BLOCK_LEN*F.channels)
+	framesDelivered := 0
+	startTime := time.Now()
+	for {
+		select {
+		case <-F.stop:
+			return
+		default:
+		}
+
+		numFrames := read(block)
+		if numFrames <= 0 {
+			return
+		}
+		handler(block[:numFrames*F.channels])
+		framesDelivered += numFrames
+
+		if F.speed > 0 {
+			// Waits until the wall clock reaches the time of the next block.
+			target := time.Duration(float64(framesDelivered) / F.sampleRate / F.speed * float64(time.Second))
+			if wait := target - time.Since(startTime); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+	}
+}
+
+func (F *pacedFeeder) Stop() error {
+	F.stopOnce.Do(func() { close(F.stop) })
+	return nil
+}
+
+func (F *pacedFeeder) SampleRate() float64 {
+	return F.sampleRate
+}
+
+func (F *pacedFeeder) Channels() int {
+	return F.channels
+}
+
+func (F *pacedFeeder) Done() <-chan struct{} {
+	return F.done
+}
+
+func (F *pacedFeeder) Err() error {
+	select {
+	case <-F.done:
+		return F.err
+	default:
+		// Still reading, err may be changing.
+		return nil
+	}
+}
+
+func (F *pacedFeeder) Live() bool {
+	return false
+}
+
+//##################
+// WAV file source.
+
+type wavFileSource struct {
+	pacedFeeder
+	samples []float32 // Interleaved samples of the whole file.
+	pos     int       // Next sample to deliver.
+}
+
+func newWavFileSource(wavFilePathAndName string, speed float64) (*wavFileSource, error) {
+	samples, info, err := readWavFile(wavFilePathAndName)
+	if err != nil {
+		return nil, err
+	}
+	return &wavFileSource{
+		pacedFeeder: newPacedFeeder(float64(info.SampleRate), info.Channels, speed),
+		samples:     samples,
+	}, nil
+}
+
+func (W *wavFileSource) Start(handler AudioFrameHandler) error {
+	go W.run(handler, func(block []float32) int {
+		n := copy(block, W.samples[W.pos:])
+		W.pos += n
+		return n / W.channels
+	})
+	return nil
+}
+
+func (W *wavFileSource) Close() error {
+	return W.Stop()
+}
+
+//##################
+// Raw PCM source, little endian samples from a reader like stdin.
+
+const (
+	PCM_FORMAT_S16LE string = "s16le" // Signed 16 bit integers.
+	PCM_FORMAT_F32LE string = "f32le" // 32 bit IEEE floats.
+)
+
+type rawPCMSource struct {
+	pacedFeeder
+	reader         io.Reader
+	format         string
+	bytesPerSample int
+	raw            []byte
+}
+
+func newRawPCMSource(reader io.Reader, format string, sampleRate int, channels int, speed float64) (*rawPCMSource, error) {
+	bytesPerSample := 0
+	switch format {
+	case PCM_FORMAT_S16LE:
+		bytesPerSample = 2
+	case PCM_FORMAT_F32LE:
+		bytesPerSample = 4
+	default:
+		return nil, fmt.Errorf("unknown raw PCM format %q, use %s or %s", format, PCM_FORMAT_S16LE, PCM_FORMAT_F32LE)
+	}
+	if sampleRate < 1 || channels < 1 {
+		return nil, fmt.Errorf("invalid raw PCM format: %d channels at %d Hz", channels, sampleRate)
+	}
+	return &rawPCMSource{
+		pacedFeeder:    newPacedFeeder(float64(sampleRate), channels, speed),
+		reader:         reader,
+		format:         format,
+		bytesPerSample: bytesPerSample,
+		raw:            make([]byte, SOURCE_BLOCK_LEN*channels*bytesPerSample),
+	}, nil
+}
+
+func (R *rawPCMSource) Start(handler AudioFrameHandler) error {
+	go R.run(handler, func(block []float32) int {
+		// Waits for a full block, only the end of the stream gives a shorter one.
+		n, err := io.ReadFull(R.reader, R.raw)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			// A broken pipe or an I/O error isn't the end of the audio.
+			R.err = err
+			return 0
+		}
+		numFrames := n / (R.bytesPerSample * R.channels)
+		numSamples := numFrames * R.channels
+		if R.format == PCM_FORMAT_F32LE {
+			for i := 0; i < numSamples; i++ {
+				block[i] = math.Float32frombits(binary.LittleEndian.Uint32(R.raw[4*i:]))
+			}
+		} else {
+			for i := 0; i < numSamples; i++ {
+				block[i] = float32(int16(binary.LittleEndian.Uint16(R.raw[2*i:]))) / 32768
+			}
+		}
+		return numFrames
+	})
+	return nil
+}
+
+func (R *rawPCMSource) Close() error {
+	return R.Stop()
+}
+
+//##################
+// Synthetic tone generator, a sine wave at a fixed frequency.
+
+type toneSource struct {
+	pacedFeeder
+	frequency float64
+	phase     float64
+}
+
+func newToneSource(frequency float64, sampleRate int, speed float64) *toneSource {
+	return &toneSource{
+		pacedFeeder: newPacedFeeder(float64(sampleRate), 1, speed),
+		frequency:   frequency,
+	}
+}
+
+func (T *toneSource) Start(handler AudioFrameHandler) error {
+	go T.run(handler, func(block []float32) int {
+		phaseStep := 2 * math.Pi * T.frequency / T.sampleRate
+		for i := range block {
+			block[i] = float32(0.5 * math.Sin(T.phase))
+			T.phase = math.Mod(T.phase+phaseStep, 2*math.Pi)
+		}
+		return len(block)
+	})
+	return nil
+}
+
+func (T *toneSource) Close() error {
+	return T.Stop()
+}
@@ -0,0 +1,115 @@
+// Tests of the audio sources that aren't a sound card.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"testing/iotest"
+)
+
+// Samples of f32le raw PCM, the ones of s16le are le16.
+func f32le(samples ...float32) []byte {
+	raw := []byte{}
+	for _, sample := range samples {
+		raw = append(raw, le32(math.Float32bits(sample))...)
+	}
+	return raw
+}
+
+// Reads all the samples of a raw PCM source, as fast as possible.
+func readRawPCM(t *testing.T, reader io.Reader, format string, channels int) ([]float32, error) {
+	source, err := newRawPCMSource(reader, format, 44100, channels, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := []float32{}
+	if err := source.Start(func(block []float32) {
+		if len(block)%channels != 0 {
+			t.Errorf("a block of %d samples, not whole frames of %d channels", len(block), channels)
+		}
+		samples = append(samples, block...)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-source.Done()
+	return samples, source.Err()
+}
+
+func TestRawPCMSource(t *testing.T) {
+	long := make([]int16, SOURCE_BLOCK_LEN+3)
+	longWant := make([]float32, len(long))
+	for i := range long {
+		long[i] = int16(i)
+		longWant[i] = float32(i) / 32768
+	}
+	tests := []struct {
+		name     string
+		format   string
+		channels int
+		raw      []byte
+		want     []float32
+	}{
+		{"s16le", PCM_FORMAT_S16LE, 1, le16(0, 16384, -32768, 32767), []float32{0, 0.5, -1, 32767.0 / 32768}},
+		{"s16le stereo", PCM_FORMAT_S16LE, 2, le16(8192, -8192, 16384, -16384), []float32{0.25, -0.25, 0.5, -0.5}},
+		{"f32le", PCM_FORMAT_F32LE, 1, f32le(0.25, -1, 0.75), []float32{0.25, -1, 0.75}},
+		{"f32le stereo", PCM_FORMAT_F32LE, 2, f32le(0.5, -0.5, 1, -1), []float32{0.5, -0.5, 1, -1}},
+		{"incomplete frame", PCM_FORMAT_S16LE, 2, le16(8192, -8192, 16384), []float32{0.25, -0.25}},
+		{"incomplete sample", PCM_FORMAT_F32LE, 1, f32le(0.5)[:3], []float32{}},
+		{"incomplete sample after a frame", PCM_FORMAT_S16LE, 1, append(le16(16384), 1), []float32{0.5}},
+		{"more than a block", PCM_FORMAT_S16LE, 1, le16(long...), longWant},
+		{"empty", PCM_FORMAT_S16LE, 1, []byte{}, []float32{}},
+	}
+	for _, test := range tests {
+		samples, err := readRawPCM(t, bytes.NewReader(test.raw), test.format, test.channels)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(samples) != len(test.want) {
+			t.Errorf("%s: %d samples, want %d", test.name, len(samples), len(test.want))
+			continue
+		}
+		for i := range samples {
+			if samples[i] != test.want[i] {
+				t.Errorf("%s: sample %d is %g, want %g", test.name, i, samples[i], test.want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestRawPCMSourceReadError(t *testing.T) {
+	broken := errors.New("broken pipe")
+	block := make([]int16, SOURCE_BLOCK_LEN)
+	reader := io.MultiReader(bytes.NewReader(le16(block...)), bytes.NewReader(le16(1, 2, 3)), iotest.ErrReader(broken))
+	samples, err := readRawPCM(t, reader, PCM_FORMAT_S16LE, 1)
+	if err != broken {
+		t.Errorf("error %v, want %v", err, broken)
+	}
+	if len(samples) != SOURCE_BLOCK_LEN {
+		t.Errorf("%d samples before the error, want the %d of the whole block", len(samples), SOURCE_BLOCK_LEN)
+	}
+}
+
+func TestNewRawPCMSourceErrors(t *testing.T) {
+	tests := []struct {
+		format     string
+		sampleRate int
+		channels   int
+	}{
+		{"s24le", 44100, 1},
+		{"S16LE", 44100, 1},
+		{PCM_FORMAT_S16LE, 0, 1},
+		{PCM_FORMAT_F32LE, 44100, 0},
+		{PCM_FORMAT_F32LE, -48000, 2},
+	}
+	for _, test := range tests {
+		if _, err := newRawPCMSource(bytes.NewReader(nil), test.format, test.sampleRate, test.channels, 0); err == nil {
+			t.Errorf("%s, %d Hz, %d channels: no error", test.format, test.sampleRate, test.channels)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Game configuration.
+//
+// Choices that should survive between runs, like the input device, are kept
+// in a small JSON file next to the music scores. A missing file simply means
+// the default configuration.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const CONFIG_FILE_PATH_AND_NAME string = "./galileu_flute_config.json"
+
+type GameConfig struct {
+	InputDevice   string  `json:"inputDevice"`   // Index or part of the name of the input device, empty for the default device.
+	InputLatency  float64 `json:"inputLatency"`  // Seconds from playing a note to the game detecting it, see calibrate-latency.
+	PitchDetector string  `json:"pitchDetector"` // Pitch detection algorithm, empty for the default, see newPitchDetector.
+	A4Frequency   float64 `json:"a4Frequency"`   // Reference pitch in Hz, 0 for the default, see tuning.go.
+	Instrument    string  `json:"instrument"`    // Recorder, empty for the soprano, see tuning.go.
+}
+
+func loadGameConfig() GameConfig {
+	config := GameConfig{}
+	raw, err := ioutil.ReadFile(CONFIG_FILE_PATH_AND_NAME)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println("Error Reading the configuration file, using the defaults!")
+			fmt.Println(err.Error())
+		}
+		return config
+	}
+	err = json.Unmarshal(raw, &config)
+	if err != nil {
+		fmt.Println("Error while parsing the configuration file, using the defaults!")
+		fmt.Println(err.Error())
+		return GameConfig{}
+	}
+	return config
+}
+
+// Tells that a choice is kept in the configuration file for the next runs, and
+// how to go back to the default, so it doesn't change the game unnoticed.
+func printSavedChoice(flagName string, value string, defaultValue string) {
+	fmt.Printf(" --%s %s is saved in %s for the next runs, --%s %s goes back to the default.\n",
+		flagName, value, CONFIG_FILE_PATH_AND_NAME, flagName, defaultValue)
+}
+
+func saveGameConfig(config GameConfig) {
+	raw, err := json.MarshalIndent(config, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(CONFIG_FILE_PATH_AND_NAME, append(raw, '\n'), 0644)
+	}
+	if err != nil {
+		fmt.Println("Error Writing the configuration file!")
+		fmt.Println(err.Error())
+	}
+}
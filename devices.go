@@ -0,0 +1,85 @@
+// Audio device enumeration and selection.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// Prints every host API with its devices, their channels and default sample rate.
+// portaudio must be initialized.
+func listDevices() error {
+	hostApis, err := portaudio.HostApis()
+	if err != nil {
+		return err
+	}
+	defaultHostApi, err := portaudio.DefaultHostApi()
+	if err != nil {
+		defaultHostApi = nil
+	}
+
+	for i, h := range hostApis {
+		defaultMark := ""
+		if h == defaultHostApi {
+			defaultMark = " (default)"
+		}
+		fmt.Printf("\nHost API %d: %s%s\n", i, h.Name, defaultMark)
+		for _, d := range h.Devices {
+			defaultMark = ""
+			if d == h.DefaultInputDevice {
+				defaultMark += " (default input)"
+			}
+			if d == h.DefaultOutputDevice {
+				defaultMark += " (default output)"
+			}
+			fmt.Printf("   %3d: %-40s in: %2d  out: %2d  %6.0f Hz%s\n",
+				d.Index, d.Name, d.MaxInputChannels, d.MaxOutputChannels, d.DefaultSampleRate, defaultMark)
+		}
+	}
+	fmt.Printf("\nSelect the input device with --device <number> or --device <part of the name>\n")
+	return nil
+}
+
+// Finds the input device from its index in list-devices or from a part of
+// its name, ignoring case. An empty selector is the default input device of
+// the default host API. portaudio must be initialized.
+func findInputDevice(selector string) (*portaudio.DeviceInfo, error) {
+	if selector == "" {
+		h, err := portaudio.DefaultHostApi()
+		if err != nil {
+			return nil, err
+		}
+		if h.DefaultInputDevice == nil {
+			return nil, fmt.Errorf("the host API %s has no default input device", h.Name)
+		}
+		return h.DefaultInputDevice, nil
+	}
+
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	if index, err := strconv.Atoi(selector); err == nil {
+		for _, d := range devices {
+			if d.Index == index {
+				if d.MaxInputChannels < 1 {
+					return nil, fmt.Errorf("device %d (%s) has no input channels", index, d.Name)
+				}
+				return d, nil
+			}
+		}
+		return nil, fmt.Errorf("there is no device with number %d, see list-devices", index)
+	}
+
+	for _, d := range devices {
+		if d.MaxInputChannels > 0 && strings.Contains(strings.ToLower(d.Name), strings.ToLower(selector)) {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("there is no input device with %q in the name, see list-devices", selector)
+}
@@ -20,6 +20,60 @@
 //    	galileu_flute.exe ./music_02.json
 //    or reading from a specific simplified ABC file format (extension .ABC or .abc)
 //      galileu_flute.exe ./music_01.ABC
+//    or replaying a recorded WAV file instead of the microphone
+//      galileu_flute.exe --input ./recording.wav ./music_01.json
+//    (add --speed 0 to replay it as fast as possible)
+//    or without a sound card, from raw PCM on stdin (s16le or f32le, any rate)
+//      arecord -f S16_LE -r 44100 -c 1 | galileu_flute.exe --input - --speed 0 ./music_01.json
+//      ffmpeg -i song.mp3 -f f32le -ar 48000 -ac 2 - | galileu_flute.exe --input - \
+//            --pcm-format f32le --pcm-rate 48000 --pcm-channels 2 ./music_01.json
+//    (a live capture like arecord already comes in real time, --speed 0 reads it as it arrives)
+//    or from a synthetic test tone at a frequency in Hz
+//      galileu_flute.exe --input tone:985 ./music_01.json
+//    or from a synthetic recorder that plays the music score perfectly
+//      galileu_flute.exe --input synth --speed 0 ./music_01.json
+//    (--synth-vibrato and --synth-detune, in cents, make it less perfect)
+//    (--synth-rate generates it at another sample rate, like 48000)
+//    or listing the audio devices, to choose the microphone by number or name
+//      galileu_flute.exe list-devices
+//      galileu_flute.exe --device "USB" ./music_01.json
+//    (the chosen device is saved in galileu_flute_config.json for the next run)
+//    or hearing yourself in the headphones while playing
+//      galileu_flute.exe --monitor --monitor-gain 0.5 ./music_01.json
+//    or changing the pitch analysis windows, length and hop in samples
+//      galileu_flute.exe --window 2048 --hop 512 ./music_01.json
+//    (short windows answer faster, they must hold two periods of the lowest note, 206 samples at 44100 Hz)
+//    or choosing the pitch detection algorithm, yin (default), mpm, acf or hps
+//      galileu_flute.exe --pitch mpm ./music_01.json
+//    (the chosen algorithm is saved in galileu_flute_config.json for the next runs, --pitch yin goes back to the default)
+//    or asking for a more certain pitch, from 0 to 1, less certain notes show ? and do not count
+//      galileu_flute.exe --min-confidence 0.95 ./music_01.json
+//    (by default 0.9 with yin and 0.8 with mpm, acf and hps, their confidences aren't on the same scale)
+//    or steadier notes, a longer median filter, more hysteresis in cents and a longer shortest note
+//      galileu_flute.exe --median-frames 7 --note-hysteresis 40 --min-note 50ms ./music_01.json
+//    or tuning the notes to another reference pitch, 440 Hz by default, like 415, 442 or 443
+//      galileu_flute.exe --a4 442 ./music_01.json
+//    or playing an alto recorder, or a sopranino or tenor, with the fingerings of the soprano songs
+//      galileu_flute.exe --instrument alto ./music_01.json
+//    (both are saved in galileu_flute_config.json for the next runs, --a4 440 --instrument soprano go back to the defaults)
+//    or asking for a more precise pitch, the cents from a note that are in tune, 25 by default
+//      galileu_flute.exe --tolerance 15 ./music_01.json
+//    (a note played sharp or flat gets half the points, from 50 to 100 cents away it's * and a miss)
+//    or calibrating the notes of your recorder, holding each note from DO to DO_HIGH
+//      galileu_flute.exe calibrate
+//    (the notes are saved in galileu_flute_profile.json and used by the game, --profile chooses another file)
+//    or recording the session, the audio and a .json file of what the game heard
+//      galileu_flute.exe --record ./session.wav ./music_01.json
+//    or in a noisy room, raising the noise gate, quieter sound is silence
+//      galileu_flute.exe --gate -40 --gate-attack 20ms --gate-release 100ms ./music_01.json
+//    or measuring the input latency, playing a short note on every click, with headphones
+//      galileu_flute.exe calibrate-latency
+//    (it is saved in galileu_flute_config.json, --latency 120 sets it in milliseconds)
+//    or measuring the accuracy of the pitch detectors on WAV files with Audacity label files (.txt)
+//      galileu_flute.exe bench-pitch ./corpus
+//    (without a directory it uses synthesized recorder tones, --pitch mpm measures only one)
+//    or learning to hold a steady note in tune, a chromatic tuner without the music score
+//      galileu_flute.exe tune
 //
 // Example of the output:
 //
@@ -27,6 +81,7 @@
 //         Galileu's Flute
 //
 //              Score: 156
+//  Level [#############.......]  -21 dB
 //   ---
 //  | = |
 //  |   |
@@ -54,6 +109,13 @@
 //  At the Score Line:
 //   'X' - You hit the correct note, 10 point's.
 //   '@' - You hit the wrong note, -1 point.
+//   '?' - The note wasn't clear enough to judge, no points.
+//   'x' - You hit the note, but didn't start it with the tongue, 5 point's.
+//   '~' - You hit the note, but sharp or flat, 5 point's.
+//         Both, not tongued and sharp or flat, it shows 'x' with 2 point's.
+//   '*' - The right note, but more than 50 cents out of tune, you lose 1 point.
+//   '!' - The flute squeaked, blown too hard, you lose 1 point.
+//   'v' - Above the line, you started a note.
 //   '|' - Just the indication of the line.
 //
 //  To Exit the program:
@@ -76,12 +138,214 @@ import (
 	"os"
 	"encoding/json"
 	"strings"
+	"flag"
+	"sync/atomic"
+	"os/signal"
 )
 
 var musicNote MusicNote = MusicNote{}
 
+// Parses the command line and returns the arguments that aren't flags. The
+// flags may also come after a command or the music file, like
+// calibrate --profile alto.json, the parsing goes on after each argument.
+func parseArguments(flags *flag.FlagSet, commandLine []string) ([]string, error) {
+	arguments := []string{}
+	err := flags.Parse(commandLine)
+	for err == nil && flags.NArg() > 0 {
+		arguments = append(arguments, flags.Arg(0))
+		err = flags.Parse(flags.Args()[1:])
+	}
+	return arguments, err
+}
 
 func main() {
+	inputName := flag.String("input", "", "Audio input instead of the microphone: a WAV file, - for raw PCM on stdin, tone:<Hz> or synth.")
+	inputSpeed := flag.Float64("speed", 1.0, "Replay speed of the --input, 1 is real time, 0 is as fast as possible, use 0 for a live capture on stdin.")
+	pcmFormat := flag.String("pcm-format", PCM_FORMAT_S16LE, "Sample format of the raw PCM on stdin, s16le or f32le.")
+	pcmSampleRate := flag.Int("pcm-rate", YIN_SAMPLING_RATE, "Sample rate in Hz of the raw PCM on stdin.")
+	pcmChannels := flag.Int("pcm-channels", 1, "Number of interleaved channels of the raw PCM on stdin.")
+	synthVibrato := flag.Float64("synth-vibrato", 0, "Vibrato depth in cents of the --input synth recorder.")
+	synthDetune := flag.Float64("synth-detune", 0, "Detune in cents of the --input synth recorder.")
+	synthSampleRate := flag.Int("synth-rate", YIN_SAMPLING_RATE, "Sample rate in Hz of the --input synth and tone generators.")
+	monitor := flag.Bool("monitor", false, "Plays the microphone in the headphones while playing.")
+	monitorGain := flag.Float64("monitor-gain", 1.0, "Gain of the microphone in the headphones with --monitor.")
+	windowLen := flag.Int("window", DEFAULT_WINDOW_LEN, "Length in samples of the pitch analysis window.")
+	hopLen := flag.Int("hop", DEFAULT_HOP_LEN, "Samples between the start of two analysis windows.")
+	recordFilePathAndName := flag.String("record", "", "Records the audio to this WAV file, with a JSON file of what the game detected.")
+	gateThreshold := flag.Float64("gate", DEFAULT_GATE_THRESHOLD_DB, "Noise gate threshold in dBFS, quieter input is silence. -120 disables the gate.")
+	gateAttack := flag.Duration("gate-attack", DEFAULT_GATE_ATTACK, "Time the input must be above the gate threshold to be sound.")
+	gateRelease := flag.Duration("gate-release", DEFAULT_GATE_RELEASE, "Time the input must be below the gate threshold to be silence.")
+	pitchDetector := flag.String("pitch", "", "Pitch detection algorithm: yin, mpm, acf or hps. It's saved for the next run.")
+	minConfidence := flag.Float64("min-confidence", -1, "Confidence, from 0 to 1, the pitch needs to be a note, below it's uncertain and doesn't count. -1 uses the default of the --pitch, 0.9 for yin and 0.8 for the others.")
+	medianFrames := flag.Int("median-frames", DEFAULT_MEDIAN_FRAMES, "Frames of the median filter of the detected frequency, 1 disables it.")
+	noteHysteresis := flag.Float64("note-hysteresis", DEFAULT_NOTE_HYSTERESIS_CENTS, "Cents another note must be closer by to replace the current note.")
+	minNoteDuration := flag.Duration("min-note", DEFAULT_MIN_NOTE_DURATION, "Shortest note, or silence, that replaces the current note.")
+	a4Frequency := flag.Float64("a4", 0, "Reference pitch of A4 in Hz the notes are tuned to, 440 by default. It's saved for the next run.")
+	instrument := flag.String("instrument", "", "Recorder played: sopranino, soprano, alto or tenor, soprano by default. It's saved for the next run.")
+	profilePathAndName := flag.String("profile", DEFAULT_PROFILE_PATH_AND_NAME, "Note profile written by calibrate and used by the game, empty to use the tuning.")
+	toleranceCents := flag.Float64("tolerance", DEFAULT_TUNE_TOLERANCE_CENTS, "Cents from a note that are in tune, farther is sharp or flat and half the points.")
+	latency := flag.Float64("latency", -1, "Input latency in milliseconds, -1 uses the one measured by calibrate-latency.")
+	inputDevice := flag.String("device", "", "Number or part of the name of the microphone device, see list-devices. It's saved for the next run.")
+	arguments, err := parseArguments(flag.CommandLine, os.Args[1:])
+	chk(err)
+	command := ""
+	if len(arguments) > 0 {
+		command = arguments[0]
+	}
+
+	config := loadGameConfig()
+	if *inputDevice != "" {
+		config.InputDevice = *inputDevice
+	}
+	if *pitchDetector != "" {
+		config.PitchDetector = *pitchDetector
+	}
+	detectorName := config.PitchDetector
+	if detectorName == "" {
+		detectorName = DEFAULT_PITCH_DETECTOR
+	}
+	if err := validatePitchDetector(detectorName); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if *a4Frequency != 0 {
+		config.A4Frequency = *a4Frequency
+	}
+	if *instrument != "" {
+		config.Instrument = *instrument
+	}
+	if config.A4Frequency != 0 {
+		tuningA4Frequency = config.A4Frequency
+	}
+	if config.Instrument != "" {
+		tuningInstrument = config.Instrument
+	}
+	tuningToleranceCents = *toleranceCents
+	if err := validateTuning(tuningA4Frequency, tuningInstrument, tuningToleranceCents); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	// The instrument is accepted in any case, the profile saves it in lower case.
+	tuningInstrument = strings.ToLower(tuningInstrument)
+
+	if err := validateAnalysisWindow(*windowLen, *hopLen); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if err := validateNoiseGate(*gateThreshold, *gateAttack, *gateRelease); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if *minConfidence >= 0 {
+		if err := validateMinConfidence(*minConfidence); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+	if err := validateNoteTracker(*medianFrames, *noteHysteresis, *minNoteDuration); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	analysisOptions := AnalysisOptions{
+		WindowLen:           *windowLen,
+		HopLen:              *hopLen,
+		GateThresholdDB:     *gateThreshold,
+		GateAttack:          *gateAttack,
+		GateRelease:         *gateRelease,
+		InputLatency:        time.Duration(config.InputLatency * float64(time.Second)),
+		PitchDetector:       detectorName,
+		MinConfidence:       defaultMinConfidence(detectorName),
+		MedianFrames:        *medianFrames,
+		NoteHysteresisCents: *noteHysteresis,
+		MinNoteDuration:     *minNoteDuration,
+	}
+	if *minConfidence >= 0 {
+		analysisOptions.MinConfidence = *minConfidence
+	}
+	if *latency >= 0 {
+		analysisOptions.InputLatency = time.Duration(*latency * float64(time.Millisecond))
+	}
+
+	inputOptions := AudioInputOptions{
+		Input:         *inputName,
+		Device:        config.InputDevice,
+		Monitor:       *monitor,
+		MonitorGain:   *monitorGain,
+		Speed:         *inputSpeed,
+		PCMFormat:     *pcmFormat,
+		PCMSampleRate: *pcmSampleRate,
+		PCMChannels:   *pcmChannels,
+		SynthVibrato:  *synthVibrato,
+		SynthDetune:   *synthDetune,
+		SynthRate:     *synthSampleRate,
+	}
+
+	if command == "list-devices" {
+		chk(portaudio.Initialize())
+		defer portaudio.Terminate()
+		chk(listDevices())
+		return
+	}
+
+	if command == "bench-pitch" {
+		directory := ""
+		if len(arguments) > 1 {
+			directory = arguments[1]
+		}
+		if err := benchPitch(directory, *pitchDetector, *minConfidence, analysisOptions); err != nil {
+			fmt.Println("Error in the pitch benchmark!")
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if command == "calibrate-latency" {
+		// The analysis searches the frequency range of the flute notes.
+		musicNote.MNnew()
+		chk(portaudio.Initialize())
+		defer portaudio.Terminate()
+		measured, err := calibrateLatency(config.InputDevice, analysisOptions)
+		if err != nil {
+			fmt.Println("Error calibrating the latency!")
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("\n Input latency: %d ms, saved in %s\n", measured.Milliseconds(), CONFIG_FILE_PATH_AND_NAME)
+		config.InputLatency = measured.Seconds()
+		saveGameConfig(config)
+		return
+	}
+
+	if command == "calibrate" {
+		musicNote.MNnew()
+		if *inputName == "" {
+			chk(portaudio.Initialize())
+			defer portaudio.Terminate()
+		}
+		if err := runNoteCalibration(inputOptions, analysisOptions, *profilePathAndName); err != nil {
+			fmt.Println("Error calibrating the notes!")
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("\n Notes calibrated, saved in %s\n", *profilePathAndName)
+		return
+	}
+
+	if command == "tune" {
+		musicNote.MNnew()
+		if *inputName == "" {
+			chk(portaudio.Initialize())
+			defer portaudio.Terminate()
+		}
+		if err := runTuner(inputOptions, analysisOptions); err != nil {
+			fmt.Println("Error in the tuner!")
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Print's the manual.
 	fmt.Printf("%s", manual)
 	time.Sleep(5 * time.Second)  // 5 seconds.
@@ -91,8 +355,8 @@ func main() {
 	// fmt.Printf("\n str_json_test: \n\n%s\n\n", str_json_test)
 
 	jsonFilePathAndName := ""
-	if len(os.Args) > 1{
-		jsonFilePathAndName = os.Args[1]
+	if len(arguments) > 0 {
+		jsonFilePathAndName = arguments[0]
 
 		if strings.HasSuffix(jsonFilePathAndName, ".abc") ||
 		   strings.HasSuffix(jsonFilePathAndName, ".ABC"){
@@ -112,38 +376,174 @@ func main() {
 	music_01.MSResetToRepeat()
 	time.Sleep(2 * time.Second)  // 2 seconds.
 
-	portaudio.Initialize()
-	defer portaudio.Terminate()
-	e := newMicophone(time.Second / 3)
-	defer e.Close()
-	chk(e.Start())
-	time.Sleep(15* 60 * time.Second)  // 15 minuts ou Ctrl + C
-	chk(e.Stop())
+	if *inputName == "" {
+		portaudio.Initialize()
+		defer portaudio.Terminate()
+	}
+	gameInit()
+	if *profilePathAndName != "" {
+		profile, err := loadNoteProfile(*profilePathAndName)
+		if err != nil {
+			fmt.Println("Error reading the note profile, using the tuning!")
+			fmt.Println(err.Error())
+		} else if profile != nil && (!strings.EqualFold(profile.Instrument, tuningInstrument) || profile.A4Frequency != tuningA4Frequency) {
+			fmt.Printf("The note profile is for the %s at %g Hz, using the tuning of the %s at %g Hz.\n",
+				profile.Instrument, profile.A4Frequency, tuningInstrument, tuningA4Frequency)
+		} else if profile != nil {
+			musicNote.MNApplyProfile(profile)
+			fmt.Printf("Notes of the profile %s.\n", *profilePathAndName)
+		}
+	}
+	source, err := openAudioSource(inputOptions)
+	if err != nil {
+		fmt.Println("Error opening the audio input!")
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	defer source.Close()
+	if err := validateWindowForRange(*windowLen, source.SampleRate()); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if (*inputDevice != "" && *inputName == "") || *pitchDetector != "" || *a4Frequency != 0 || *instrument != "" {
+		// The device works, or the detector or tuning was chosen, remembers them for the next run.
+		saveGameConfig(config)
+	}
+	savedChoices := false
+	if config.PitchDetector != "" && config.PitchDetector != DEFAULT_PITCH_DETECTOR {
+		printSavedChoice("pitch", config.PitchDetector, DEFAULT_PITCH_DETECTOR)
+		savedChoices = true
+	}
+	if config.A4Frequency != 0 && config.A4Frequency != DEFAULT_A4_FREQUENCY {
+		printSavedChoice("a4", fmt.Sprintf("%g", config.A4Frequency), fmt.Sprintf("%g", DEFAULT_A4_FREQUENCY))
+		savedChoices = true
+	}
+	if config.Instrument != "" && !strings.EqualFold(config.Instrument, DEFAULT_INSTRUMENT) {
+		printSavedChoice("instrument", config.Instrument, DEFAULT_INSTRUMENT)
+		savedChoices = true
+	}
+	if savedChoices {
+		// Time to read them before the game starts.
+		time.Sleep(2 * time.Second)
+	}
+
+	if *recordFilePathAndName != "" {
+		session, err = newSessionRecorder(*recordFilePathAndName, music_01.Name, source.SampleRate(), analysisOptions)
+		if err != nil {
+			fmt.Println("Error creating the recording WAV file!")
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Ctrl + C ends the game cleanly, so the recording is complete.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	pipeline := newAudioPipeline(source, analysisOptions)
+	pipeline.Start()
+	chk(source.Start(pipeline.FrameHandler()))
+	select {
+	case <-source.Done():
+		if err := source.Err(); err != nil {
+			fmt.Println("\n\nError reading the audio input!")
+			fmt.Println(err.Error())
+		} else {
+			fmt.Printf("\n\nEnd of the audio input.\n")
+		}
+	case <-interrupt:
+	case <-time.After(15* 60 * time.Second):  // 15 minuts ou Ctrl + C
+	}
+	chk(source.Stop())
+	pipeline.Finish()
+	printFinalScore()
+
+	if session != nil {
+		if err := session.Close(); err != nil {
+			fmt.Println("Error writing the recording!")
+			fmt.Println(err.Error())
+		} else {
+			fmt.Printf("    Recorded in %s and %s\n", *recordFilePathAndName, session.sidecarPath)
+		}
+	}
 	//fmt.Printf("len %d, cap %d\n", input_buffer_len, input_buffer_cap)
 }
 
+// The live microphone input through portaudio, an AudioSource.
 type microphone struct {
 	*portaudio.Stream
-	buffer []float32
-	i      int
+	buffer      []float32
+	i           int
+	sampleRate  float64
+	handler     AudioFrameHandler
+	monitorGain float32        // Gain of the microphone in the output, in monitor mode.
+	done        chan struct{}  // Never closed, the microphone doesn't run out of audio.
 }
 
-func newMicophone(delay time.Duration) *microphone {
+// Prepares the flute notes and the music score before the first audio arrives.
+func gameInit() {
 	// Inicializes the flute music notes.
 	musicNote.MNnew()
 	// Expand the music into a 2D array of runes with the music.
 	music_01.MSExpandIntoArray()
-	// Screen buffer where all text is written, before display.
+}
 
-	h, err := portaudio.DefaultHostApi()
-	chk(err)
-	p := portaudio.LowLatencyParameters(h.DefaultInputDevice, h.DefaultOutputDevice)
+// Opens the input device chosen by the selector, see findInputDevice.
+// The stream is input only, unless monitor is set, then the microphone is
+// also played with monitorGain on the default output of the same host API.
+func newMicophone(delay time.Duration, deviceSelector string, monitor bool, monitorGain float64) (*microphone, error) {
+	device, err := findInputDevice(deviceSelector)
+	if err != nil {
+		return nil, err
+	}
+	var outputDevice *portaudio.DeviceInfo = nil
+	if monitor {
+		outputDevice = device.HostApi.DefaultOutputDevice
+		if outputDevice == nil {
+			return nil, fmt.Errorf("the host API %s has no output device for the monitor", device.HostApi.Name)
+		}
+	}
+	p := portaudio.LowLatencyParameters(device, outputDevice)
 	p.Input.Channels = 1
-	p.Output.Channels = 1
-	e := &microphone{buffer: make([]float32, int(p.SampleRate*delay.Seconds()))}
-	e.Stream, err = portaudio.OpenStream(p, e.processAudio)
-	chk(err)
-	return e
+	e := &microphone{buffer: make([]float32, int(p.SampleRate*delay.Seconds())),
+		sampleRate:  p.SampleRate,
+		monitorGain: float32(monitorGain),
+		done:        make(chan struct{})}
+	if monitor {
+		p.Output.Channels = 1
+		e.Stream, err = portaudio.OpenStream(p, e.processAudioMonitor)
+	} else {
+		e.Stream, err = portaudio.OpenStream(p, e.processAudio)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *microphone) Start(handler AudioFrameHandler) error {
+	e.handler = handler
+	return e.Stream.Start()
+}
+
+func (e *microphone) SampleRate() float64 {
+	return e.sampleRate
+}
+
+func (e *microphone) Channels() int {
+	return 1
+}
+
+func (e *microphone) Done() <-chan struct{} {
+	return e.done
+}
+
+func (e *microphone) Err() error {
+	return nil
+}
+
+func (e *microphone) Live() bool {
+	return true
 }
 
 func chk(err error) {
@@ -159,70 +559,124 @@ var input_buffer_len int = 0
 var input_buffer_cap int = 0
 
 
-var inputBuffer [buffLen]float32 = [buffLen]float32{}
-var inputBufferIndex int = 0
-var flag_counter int = 0
+var pitchAnalyzer *slidingWindowAnalyzer = nil  // Owned by the analysis goroutine.
+var gameStepClock *gameClock = nil
+
+// Prepares the analysis for the sample rate of the audio source.
+func setupAnalysis(sampleRate float64, options AnalysisOptions) {
+	pitchAnalyzer = newSlidingWindowAnalyzer(sampleRate, options)
+	stepSeconds := GAME_STEP_SECONDS
+	if options.StepSeconds > 0 {
+		// The commands without a music score show their steps more often.
+		stepSeconds = options.StepSeconds
+	}
+	gameStepClock = newGameClock(sampleRate, stepSeconds, options.InputLatency)
+	inputMeter = newLevelMeter(options.GateThresholdDB)
+}
 
-func (e *microphone) processAudio(in, out []float32) {
+func (e *microphone) processAudio(in []float32) {
 
-	// Samples rate is 44100.
+	// Samples rate is the default of the device, see newMicophone.
     // fmt.Printf( "Sample rate:  %d\n", s.SampleRate())
 
 	input_buffer_len = len(in)
 	input_buffer_cap = cap(in)
 
+	e.handler(in)
+}
+
+// Same as processAudio, but the player also hears the microphone in the output.
+func (e *microphone) processAudioMonitor(in, out []float32) {
 	for i := range out {
-		inputBuffer[inputBufferIndex] = in[i]
-
-		if inputBufferIndex == buffLen - 1 {
-			// Process one buffer then doesn't process the next buffer of length buffLen.
-			if flag_counter == 0 {
-				// Process buffer with algoritm.
-				frequency, /*probability*/ _ := findMainFrequency(&inputBuffer)
-				//fmt.Printf("Main Frequency: %f - Probability: %f \n", frequency, probability)
-				// musicNote.MNPrintNote(frequency)
-
-				// Writes the flute drawing in text into the screenBuffer
-				playedNote := musicNote.MNPrintNoteToScreenBuffer(frequency)
-
-				// Writes the sheet music into the screen.
-				music_01.MSPrintMusicSheetToScreenBuffer(playedNote)
-				// Makes the score move from the right to the left,
-				music_01.MSUpdateMovement()
-
-				// Writes screenBuffer to the screen with Printf.
-				printScreenBuffer()
-
-				flag_counter++
-			} else {
-				// Jumps each two buffers.
-				if flag_counter < 2 {
-					flag_counter++
-				} else {
-					flag_counter = 0
-				}
+		value := in[i] * e.monitorGain
+		// Clips instead of wrapping around when the gain is too high.
+		if value > 1 {
+			value = 1
+		} else if value < -1 {
+			value = -1
+		}
+		out[i] = value
+	}
+	e.processAudio(in)
+}
+
+// Analyses a block of mono samples, calls sendFrame with the result of each game step.
+func processSamples(in []float32, sendFrame func(frame AnalysisFrame)) {
+	for i := range in {
+		if pitchFrame, ok := pitchAnalyzer.Push(in[i]); ok {
+			//fmt.Printf("Main Frequency: %f - Confidence: %f \n", pitchFrame.frequency, pitchFrame.confidence)
+			gameStepClock.AddPitchFrame(pitchFrame)
+			if session != nil {
+				session.AddPitchFrame(pitchFrame)
 			}
-			inputBufferIndex = 0
-		}else{
-			inputBufferIndex++
 		}
+		if frame, ok := gameStepClock.Tick(); ok {
+			sendFrame(frame)
+		}
+	}
+}
+
+// Runs one step of the game with the analysis of the audio, in the render goroutine.
+func renderGameStep(frame AnalysisFrame) {
+	if tuner != nil {
+		// The tune command has no music score.
+		tuner.Render(frame)
+		return
+	}
+	if noteCalibration != nil {
+		noteCalibration.Render(frame)
+		return
 	}
 
+	// musicNote.MNPrintNote(frame.frequency)
+
+	// Writes the flute drawing in text into the screenBuffer
+	playedNote := frame.note
+	musicNote.MNPrintNoteToScreenBuffer(playedNote)
+	inputMeter.Update(frame, music_01.MSNoteUnderLine())
+
+	// Writes the sheet music into the screen.
+	cents, status := musicNote.MNNoteStatus(playedNote, frame.frequency)
+	if playedNote >= 0 && frame.frequency <= 0 {
+		// The tracker held the note through frames without a pitch, the note
+		// counts, but its tuning can't be judged.
+		cents, status = 0, NOTE_IN_TUNE
+	}
+	scoredNote := playedNote
+	if playedNote == UNCERTAIN && frame.frequency > 0 {
+		// A clear pitch too far from every note is the nearest note badly out of tune.
+		match := musicNote.MNMatchNote(frame.frequency)
+		if match.status == NOTE_UNKNOWN && math.Abs(match.cents) <= NOTE_BAD_MAX_CENTS {
+			scoredNote, cents, status = match.note, match.cents, match.status
+		}
+	}
+	music_01.MSPrintMusicSheetToScreenBuffer(scoredNote, status, len(frame.onsetTimes) > 0, frame.tongued)
+	// Makes the score move from the right to the left,
+	music_01.MSUpdateMovement()
+
+	if session != nil {
+		session.AddStep(frame, scoredNote, cents, status, currentScore)
+	}
+
+	// Writes screenBuffer to the screen with Printf.
+	printScreenBuffer()
 }
 
 
 ///////////////////////////////////////////////////
 
-const buffLen int = 5000 // 11025
-
-func findMainFrequency(buff *[buffLen]float32 ) (frequency float64, probability float64){
+// Creates the Yin used by findMainFrequency, once for all the windows of bufferSize samples.
+func newPitchYin(bufferSize int, sampleRate float64) *Yin {
 	//arr :=  [YIN_SAMPLING_RATE / 2]float64{}
 	//yin := Yin{0,0, arr, 0.0, 0.0}
-	yin := Yin{}
-	//bufferSize := 44100
-	bufferSize := buffLen  // 11025
-	threashold := 0.05
-	yin.YinInit( bufferSize, threashold )
+	yin := &Yin{}
+	// The threshold only finds the candidate pitch, how much it's trusted is
+	// decided with its probability and the --min-confidence.
+	yin.YinInit( bufferSize, YIN_DEFAULT_THRESHOLD, sampleRate )
+	return yin
+}
+
+func findMainFrequency(yin *Yin, buff []float32) (frequency float64, probability float64){
 	frequency = yin.YinGetPitch(buff)
 	probability = yin.YinGetProbability()
 	return frequency, probability
@@ -231,36 +685,61 @@ func findMainFrequency(buff *[buffLen]float32 ) (frequency float64, probability
 
 //################################################################
 
+// Reference sample rate, the default of the sources that generate audio.
 const YIN_SAMPLING_RATE int = 44100
 const YIN_DEFAULT_THRESHOLD float64 = 0.15
-const BUFF_SIZE int = buffLen // 5000 // 11025 // 22050
 
 type Yin struct {
 	bufferSize     int     // Size of the buffer to process.
 	halfBufferSize int     // Half of buffer size.
-	yinBuffer      [BUFF_SIZE / 2]float64 // Buffer that stores the results of the intermediate processing steps of the algorithm
+	sampleRate     float64 // Sample rate of the buffer, to convert the shift (tau) into Hz.
+	yinBuffer      []float64 // Buffer that stores the results of the intermediate processing steps of the algorithm
 	probability    float64 // Probability that the pitch found is correct as a decimal (i.e 0.85 is 85%)
 	threshold      float64 // Allowed uncertainty in the result as a decimal (i.e 0.15 is 15%)
+	direct         bool    // Step 1 with the direct loop instead of the FFT, see yin_fft.go.
+	fft            *fftPlan
+	fftData        []complex128 // Spectrum and correlation, for the FFT.
+	energy         []float64    // Running sum of the squares of the buffer, for the FFT.
+	tauMin         int     // Shortest period searched, from the highest frequency.
+	tauEnd         int     // After the longest period searched, from the lowest frequency.
 }
 
 
 // threshold  Allowed uncertainty (e.g 0.05 will return a pitch with ~95% probability)
-func (Y *Yin) YinInit(bufferSize int, threshold float64) {
+// sampleRate Sample rate of the buffers that will be analysed.
+func (Y *Yin) YinInit(bufferSize int, threshold float64, sampleRate float64) {
 	// Initialise the fields of the Yin structure passed in.
 	Y.bufferSize = bufferSize;
 	Y.halfBufferSize = bufferSize / 2;
+	Y.sampleRate = sampleRate;
 	Y.probability = 0.0;
 	Y.threshold = threshold;
+	Y.tauMin = 2;
+	Y.tauEnd = Y.halfBufferSize;
 
 	// Allocate the autocorellation buffer and initialise it to zero.
-	Y.yinBuffer = [BUFF_SIZE / 2]float64{}
+	Y.yinBuffer = make([]float64, Y.halfBufferSize)
+}
+
+// Searches only the pitches between minFrequency and maxFrequency in Hz, the
+// range of the instrument. Pitches outside it aren't octave errors waiting to
+// happen and the shorter search is faster.
+func (Y *Yin) YinSetFrequencyRange(minFrequency float64, maxFrequency float64) {
+	// Up to two after the longest period, for the search of the minimum and the interpolation.
+	Y.tauMin, Y.tauEnd = tauRange(Y.sampleRate, minFrequency, maxFrequency, Y.halfBufferSize)
+}
+
+// Shortest window that can hold two periods of minFrequency, what YIN needs
+// to find it.
+func yinMinWindowLen(sampleRate float64, minFrequency float64) int {
+	return 2 * (int(math.Ceil(sampleRate / minFrequency)) + 2)
 }
 
 
 // Runs the Yin pitch detection algortihm
 //        buffer       - Buffer of samples to analyse
 // return pitchInHertz - Fundamental frequency of the signal in Hz. Returns -1 if pitch can't be found
-func (Y *Yin) YinGetPitch(buffer *[BUFF_SIZE]float32) (pitchInHertz float64) {
+func (Y *Yin) YinGetPitch(buffer []float32) (pitchInHertz float64) {
 	//tauEstimate int      := -1
 	pitchInHertz = -1
 
@@ -275,7 +754,7 @@ func (Y *Yin) YinGetPitch(buffer *[BUFF_SIZE]float32) (pitchInHertz float64) {
 
 	// Step 5: Interpolate the shift value (tau) to improve the pitch estimate.
 	if(tauEstimate != -1){
-		pitchInHertz = float64(YIN_SAMPLING_RATE) / Y.yinParabolicInterpolation(tauEstimate)
+		pitchInHertz = Y.sampleRate / Y.yinParabolicInterpolation(tauEstimate)
 	}
 
 	return pitchInHertz;
@@ -294,16 +773,28 @@ func (Y *Yin) YinGetProbability() (probability float64) {
 //
 // This is the Yin algorithms tweak on autocorellation. Read http://audition.ens.fr/adc/pdf/2002_JASA_YIN.pdf
 // for more details on what is in here and why it's done this way.
-func (Y *Yin) yinDifference(buffer *[BUFF_SIZE]float32) {
-	// Calculate the difference for difference shift values (tau) for the half of the samples.
-	for tau := 0; tau < Y.halfBufferSize; tau++ {
+func (Y *Yin) yinDifference(buffer []float32) {
+	if Y.direct {
+		Y.yinDifferenceDirect(buffer)
+	} else {
+		Y.yinDifferenceFFT(buffer)
+	}
+}
+
+// Step 1 with a loop for every shift, O(N^2), the reference for yinDifferenceFFT.
+func (Y *Yin) yinDifferenceDirect(buffer []float32) {
+	// Calculate the difference for difference shift values (tau) for the half of the samples,
+	// up to the longest period searched.
+	for tau := 0; tau < Y.tauEnd; tau++ {
 
 		// Take the difference of the signal with a shifted version of itself, then square it.
 		// (This is the Yin algorithm's tweak on autocorellation)
+		sum := 0.0
 		for i := 0; i < Y.halfBufferSize; i++{
 			delta := float64(buffer[i]) - float64(buffer[i + tau])
-			Y.yinBuffer[tau] += delta * delta;
+			sum += delta * delta;
 		}
+		Y.yinBuffer[tau] = sum
 	}
 }
 
@@ -318,8 +809,13 @@ func (Y *Yin) yinCumulativeMeanNormalizedDifference() {
 
 	// Sum all the values in the autocorellation buffer and nomalise the result, replacing
 	// the value in the autocorellation buffer with a cumulative mean of the normalised difference.
-	for tau := 1; tau < Y.halfBufferSize; tau++ {
+	for tau := 1; tau < Y.tauEnd; tau++ {
 		runningSum += Y.yinBuffer[tau]
+		if runningSum == 0 {
+			// A silent buffer, nothing repeats, instead of dividing zero by zero.
+			Y.yinBuffer[tau] = 1
+			continue
+		}
 		Y.yinBuffer[tau] *= float64(tau) / runningSum
 	}
 }
@@ -334,9 +830,10 @@ func (Y *Yin) yinAbsoluteThreshold() int {
 
 	// Search through the array of cumulative mean values, and look for ones that are over the threshold
 	// The first two positions in yinBuffer are always so start at the third (index 2)
-	for tau = 2; tau < Y.halfBufferSize; tau++ {
+	// Only the shifts of the frequency range, see YinSetFrequencyRange.
+	for tau = Y.tauMin; tau < Y.tauEnd; tau++ {
 		if (Y.yinBuffer[tau] < Y.threshold) {
-			for (tau + 1 < Y.halfBufferSize) && (Y.yinBuffer[tau + 1] < Y.yinBuffer[tau]) {
+			for (tau + 1 < Y.tauEnd) && (Y.yinBuffer[tau + 1] < Y.yinBuffer[tau]) {
 				tau++;
 			}
 
@@ -355,7 +852,7 @@ func (Y *Yin) yinAbsoluteThreshold() int {
 	}
 
 	// if no pitch found, tau => -1
-	if (tau == Y.halfBufferSize || Y.yinBuffer[tau] >= Y.threshold) {
+	if (tau >= Y.tauEnd || Y.yinBuffer[tau] >= Y.threshold) {
 		tau = -1;
 		Y.probability = 0;
 	}
@@ -383,7 +880,7 @@ func (Y *Yin) yinParabolicInterpolation(tauEstimate int) float64 {
 	}
 
 	// Calculate the second polynomial coeffcient based on the current estimate of tau.
-	if tauEstimate + 1 < Y.halfBufferSize {
+	if tauEstimate + 1 < Y.tauEnd {
 		x2 = tauEstimate + 1;
 	} else {
 		x2 = tauEstimate;
@@ -428,15 +925,37 @@ const (
 	DO_HIGH
 )
 
+// Names of the flute notes, in the order of the constants.
+var fluteNoteNames = []string{"EMPTY", "DO", "RE", "MI", "FA", "SOL", "LA", "SI", "DO_HIGH"}
+
+// Not a flute note, the noise gate found no sound. Different from EMPTY, the
+// recorder with no holes covered.
+const SILENCE int = -1
+
+// Not a flute note, there is sound but the pitch detector isn't confident of
+// its pitch. It's neither a hit nor a miss.
+const UNCERTAIN int = -2
+
+// Not a flute note, the recorder was overblown and squeaked. It's a miss.
+const SQUEAK int = -3
+
 
 const fluteNoteLen int = 9
 
+// Margin of the pitch search below the lowest and above the highest note, in cents.
+const PITCH_RANGE_MARGIN_CENTS float64 = 300
+
 type MusicNote struct {
 	//description     [fluteNoteLen]string     // Description
 	note            [fluteNoteLen]string       // Name of the music note.
-	frequency       [fluteNoteLen]int          // Frequency of the music note.
+	frequency       [fluteNoteLen]float64      // Frequency of the music note, see MNTune.
+	semitones       [fluteNoteLen]int          // Semitones of the note above DO, C5 on a soprano recorder.
+	tolerance       [fluteNoteLen]float64      // Cents around the frequency that are in tune, see MNNoteStatus.
 	textFluteOutput [fluteNoteLen][13]string   // Text representation of the flute drawing.
     VisualIndex     [fluteNoteLen]int          // The index that shows visualy in the Music Score for this note.
+	textFluteSilence [13]string               // Flute drawing while nothing is played.
+	textFluteUncertain [13]string             // Flute drawing while the note played isn't certain.
+	textFluteSqueak [13]string                // Flute drawing while the flute squeaks.
 }
 
 func (MN *MusicNote) MNnew() {
@@ -444,7 +963,7 @@ func (MN *MusicNote) MNnew() {
 	noteIndex := 0
 	// Recorder with no holes covered.
 	MN.note[noteIndex]      = "Recorder with no holes covered."
-	MN.frequency[noteIndex] =  1180
+	MN.semitones[noteIndex] = 14  // D6, above DO_HIGH, no fingering closes the holes.
 	MN.VisualIndex[noteIndex] = 0
 	MN.textFluteOutput[noteIndex][ 0] = "  ---    "
 	MN.textFluteOutput[noteIndex][ 1] = " | = |   "
@@ -464,7 +983,7 @@ func (MN *MusicNote) MNnew() {
 	noteIndex = 1
 	// Do - All holes covered.
 	MN.note[noteIndex]      = "Do"
-	MN.frequency[noteIndex] =  521  // The correct should be 527 Hz, but isn't this one that comes out.
+	MN.semitones[noteIndex] = 0  // C5.
 	MN.VisualIndex[noteIndex] = 9
 	MN.textFluteOutput[noteIndex][ 0] = "  ---    "
 	MN.textFluteOutput[noteIndex][ 1] = " | = |   "
@@ -484,7 +1003,7 @@ func (MN *MusicNote) MNnew() {
 	noteIndex = 2
 	// Re - All holes covered except the last one.
 	MN.note[noteIndex]      = "Re ---A1#/B1b"
-	MN.frequency[noteIndex] =  630
+	MN.semitones[noteIndex] = 2  // D5.
 	MN.VisualIndex[noteIndex] = 8
 	MN.textFluteOutput[noteIndex][ 0] = "  ---    "
 	MN.textFluteOutput[noteIndex][ 1] = " | = |   "
@@ -504,7 +1023,7 @@ func (MN *MusicNote) MNnew() {
 	noteIndex = 3
 	// Mi - All holes covered except the last two.
 	MN.note[noteIndex]      = "Mi --- A1"
-	MN.frequency[noteIndex] =  652  // The correct should be 627 Hz.
+	MN.semitones[noteIndex] = 4  // E5.
 	MN.VisualIndex[noteIndex] = 7
 	MN.textFluteOutput[noteIndex][ 0] = "  ---    "
 	MN.textFluteOutput[noteIndex][ 1] = " | = |   "
@@ -524,7 +1043,7 @@ func (MN *MusicNote) MNnew() {
 	noteIndex = 4
 	// Fá - All holes covered except the last three.
 	MN.note[noteIndex]      = "Fá  --- G1"
-	MN.frequency[noteIndex] =  700 // Should be the frequency of 704 Hz.
+	MN.semitones[noteIndex] = 5  // F5.
 	MN.VisualIndex[noteIndex] = 6
 	MN.textFluteOutput[noteIndex][ 0] = "  ---    "
 	MN.textFluteOutput[noteIndex][ 1] = " | = |   "
@@ -544,7 +1063,7 @@ func (MN *MusicNote) MNnew() {
 	noteIndex = 5
 	// Sol - With the first three holes covered.
 	MN.note[noteIndex]      = "Sol --- F1"
-	MN.frequency[noteIndex] =  780  // Should be 790 Hz.
+	MN.semitones[noteIndex] = 7  // G5.
 	MN.VisualIndex[noteIndex] = 5
 	MN.textFluteOutput[noteIndex][ 0] = "  ---    "
 	MN.textFluteOutput[noteIndex][ 1] = " | = |   "
@@ -564,7 +1083,7 @@ func (MN *MusicNote) MNnew() {
 	noteIndex = 6
 	// La - With the first two holes covered.
 	MN.note[noteIndex]      = "La --- E1"
-	MN.frequency[noteIndex] =  882   // Should be 837 Hz.
+	MN.semitones[noteIndex] = 9  // A5.
 	MN.VisualIndex[noteIndex] = 4
 	MN.textFluteOutput[noteIndex][ 0] = "  ---    "
 	MN.textFluteOutput[noteIndex][ 1] = " | = |   "
@@ -584,7 +1103,7 @@ func (MN *MusicNote) MNnew() {
 	noteIndex = 7
 	// Si - With the first hole covered.
 	MN.note[noteIndex]      = "Si ---- D1"
-	MN.frequency[noteIndex] =  985   // Shoud be 939 Hz.
+	MN.semitones[noteIndex] = 11  // B5.
 	MN.VisualIndex[noteIndex] = 3
 	MN.textFluteOutput[noteIndex][ 0] = "  ---    "
 	MN.textFluteOutput[noteIndex][ 1] = " | = |   "
@@ -604,7 +1123,7 @@ func (MN *MusicNote) MNnew() {
 	noteIndex = 8
 	// Do high - With only two holes covered.
 	MN.note[noteIndex]      = "Do high"
-	MN.frequency[noteIndex] =  1040   // It should be 1054 Hz.
+	MN.semitones[noteIndex] = 12  // C6.
 	MN.VisualIndex[noteIndex] = 4
 	MN.textFluteOutput[noteIndex][ 0] = "  ---    "
 	MN.textFluteOutput[noteIndex][ 1] = " | = |   "
@@ -620,10 +1139,66 @@ func (MN *MusicNote) MNnew() {
 	MN.textFluteOutput[noteIndex][11] = " |   |   "
 	MN.textFluteOutput[noteIndex][12] = "  ---    "
 
+
+	// Silence - Nothing is played, the holes are not shown.
+	MN.textFluteSilence[ 0] = "  ---    "
+	MN.textFluteSilence[ 1] = " | = |   "
+	MN.textFluteSilence[ 2] = " |   |   "
+	MN.textFluteSilence[ 3] = " |   |   "
+	MN.textFluteSilence[ 4] = " |   |   "
+	MN.textFluteSilence[ 5] = " |   |   "
+	MN.textFluteSilence[ 6] = " |   |   "
+	MN.textFluteSilence[ 7] = " |   |   "
+	MN.textFluteSilence[ 8] = " |   |   "
+	MN.textFluteSilence[ 9] = " |   |   "
+	MN.textFluteSilence[10] = "  | |    "
+	MN.textFluteSilence[11] = " |   |   "
+	MN.textFluteSilence[12] = "  ---    "
+
+
+	// Uncertain - There is sound, but the note isn't clear.
+	MN.textFluteUncertain[ 0] = "  ---    "
+	MN.textFluteUncertain[ 1] = " | = |   "
+	MN.textFluteUncertain[ 2] = " |   |   "
+	MN.textFluteUncertain[ 3] = " | ? | ? "
+	MN.textFluteUncertain[ 4] = " | ? |   "
+	MN.textFluteUncertain[ 5] = " | ? |   "
+	MN.textFluteUncertain[ 6] = " | ? |   "
+	MN.textFluteUncertain[ 7] = " | ? |   "
+	MN.textFluteUncertain[ 8] = " | ? |   "
+	MN.textFluteUncertain[ 9] = " |?  |   "
+	MN.textFluteUncertain[10] = "  | |    "
+	MN.textFluteUncertain[11] = " |   |   "
+	MN.textFluteUncertain[12] = "  ---    "
+
+
+	// Squeak - The flute was overblown, blow softer.
+	MN.textFluteSqueak[ 0] = "  ---  ! "
+	MN.textFluteSqueak[ 1] = " | = | ! "
+	MN.textFluteSqueak[ 2] = " |   |   "
+	MN.textFluteSqueak[ 3] = " | ! |   "
+	MN.textFluteSqueak[ 4] = " | ! |   "
+	MN.textFluteSqueak[ 5] = " | ! |   "
+	MN.textFluteSqueak[ 6] = " | ! |   "
+	MN.textFluteSqueak[ 7] = " | ! |   "
+	MN.textFluteSqueak[ 8] = " | ! |   "
+	MN.textFluteSqueak[ 9] = " |!  |   "
+	MN.textFluteSqueak[10] = "  | |    "
+	MN.textFluteSqueak[11] = " |   |   "
+	MN.textFluteSqueak[12] = "  ---    "
+
+	// The frequencies of the notes in equal temperament.
+	MN.MNTune(tuningA4Frequency, tuningInstrument)
+	for i := 0; i < fluteNoteLen; i++ {
+		MN.tolerance[i] = tuningToleranceCents
+	}
 }
 
 func (MN *MusicNote) MNPrintNote(frequency float64) {
 	bestIndex := MN.MNFindFluteNoteIndex(frequency)
+	if bestIndex < 0 {
+		return
+	}
 
 	var buffer bytes.Buffer
 	for i:=0; i<13; i++ {
@@ -637,37 +1212,58 @@ func (MN *MusicNote) MNPrintNote(frequency float64) {
 	fmt.Printf("\n\n\n  %s\n%s", MN.note[bestIndex], str_flute)
 }
 
+// Lowest and highest frequency in Hz the flute can play, with a margin for
+// the notes played out of tune. The pitch detection searches only this range.
+func (MN *MusicNote) MNFrequencyRange() (minFrequency float64, maxFrequency float64) {
+	minFrequency = MN.frequency[0]
+	maxFrequency = MN.frequency[0]
+	for i := 1; i < fluteNoteLen; i++ {
+		minFrequency = math.Min(minFrequency, MN.frequency[i])
+		maxFrequency = math.Max(maxFrequency, MN.frequency[i])
+	}
+	margin := math.Pow(2, PITCH_RANGE_MARGIN_CENTS / 1200)
+	return minFrequency / margin, maxFrequency * margin
+}
+
+// Returns the note nearest to the frequency in cents, even when it's far from
+// every note, see MNMatchNote. -1 if no frequency was detected.
 func (MN *MusicNote) MNFindFluteNoteIndex(frequency float64) (bestIndex int) {
 	bestIndex = -1
 	lowestDelta := 9999999999.0
 
 	// If frequency is -1.0 then no frequency was detected.
-	if frequency + 1 > 0.0001 {
+	if frequency > 0 {
 		for i := 0; i < fluteNoteLen; i++ {
-			difference := frequency - float64(MN.frequency[i])
-			delta := math.Sqrt(difference * difference)
+			delta := math.Abs(centsBetween(frequency, MN.frequency[i]))
 			if delta < lowestDelta {
 				lowestDelta = delta
 				bestIndex = i
 			}
 		}
-	}else{
-		bestIndex = 0
 	}
 
 	return bestIndex
 }
 
-func (MN *MusicNote) MNPrintNoteToScreenBuffer(frequency float64) (playedNote int) {
-	bestIndex := MN.MNFindFluteNoteIndex(frequency)
+func (MN *MusicNote) MNPrintNoteToScreenBuffer(playedNote int) {
+	var textFlute [13]string
+	switch playedNote {
+	case SILENCE:
+		textFlute = MN.textFluteSilence
+	case UNCERTAIN:
+		textFlute = MN.textFluteUncertain
+	case SQUEAK:
+		textFlute = MN.textFluteSqueak
+	default:
+		textFlute = MN.textFluteOutput[playedNote]
+	}
 
 	for i:=0; i<13; i++ {
-		runesFluteLine := []rune(MN.textFluteOutput[bestIndex][i])
+		runesFluteLine := []rune(textFlute[i])
 		for j, e := range runesFluteLine{
 			screenBuffer[i][j] = e
 		}
 	}
-	return bestIndex
 }
 
 ////////////////////////////////////////////
@@ -775,7 +1371,10 @@ func (MS * MusicScore) MSExpandIntoArray() {
 	MS.expandedRunesArray = MSTextArray
 }
 
-func (MS *MusicScore) MSPrintMusicSheetToScreenBuffer(note int) {
+// The note played in the step, status tells if it was in tune, see MNNoteStatus,
+// attacked is true if a note was started in it, see onset.go, and tongued if
+// it was started in it or a moment before, see TONGUE_EARLY_SECONDS.
+func (MS *MusicScore) MSPrintMusicSheetToScreenBuffer(note int, status int, attacked bool, tongued bool) {
 
 	// Initialize the screen with '.'
 	for i:=3; i<NUM_LINES_SCREEN - 3; i++ {
@@ -797,10 +1396,19 @@ func (MS *MusicScore) MSPrintMusicSheetToScreenBuffer(note int) {
 //	indexSourceStart   int           // Index on the expandedRunesArray of the Start position. Copies from this position on the expandedRunesArray to the screenBuffer.
 //	indexTargetStart   int           // Index on the screenBuffer of the Start position.
 
-	visualIndex := musicNote.VisualIndex[note]
+	visualIndex := -1  // With silence, UNCERTAIN or SQUEAK no line of the score is played.
+	if note >= 0 {
+		visualIndex = musicNote.VisualIndex[note]
+	}
 
 	//fmt.Printf("visualIndex: %d", visualIndex)
 
+	// Shows each start of a note above the line.
+	screenBuffer[2][10] = ' '
+	if attacked {
+		screenBuffer[2][10] = 'v'
+	}
+
 	// Draw the vertical line (Win Line) on the left of the screen that markes where the notes are scorred.
 	for i:=3; i<NUM_LINES_SCREEN - 3; i++ {
 
@@ -813,23 +1421,66 @@ func (MS *MusicScore) MSPrintMusicSheetToScreenBuffer(note int) {
 			//fmt.Printf("rune: %s", string(rune) )
 
 			//if rune == 'S' || rune == '_' || rune == 'D'{
-				if note == DO_HIGH && rune != 'D' {
+				// DO_HIGH shares the line of LA, the start of the note tells them apart.
+				// The rune under the line alone isn't enough: on the '_' that holds a
+				// DO_HIGH it would make DO_HIGH a miss, and LA would hit on a 'D'.
+				if (note == DO_HIGH) != (MS.MSNoteStartRuneUnderLine(i) == 'D') {
 					screenBuffer[i][10] = '@'
+					currentMisses++
 					if currentScore > 0 {
 						// Each wrong note decreases the score by one.
 						currentScore--
 					}
 					continue
 				}
-				screenBuffer[i][10] = 'X'
+				if status == NOTE_UNKNOWN {
+					// The right fingering, but badly out of tune, a miss of its own.
+					screenBuffer[i][10] = '*'
+					currentMisses++
+					currentBadlyOutOfTune++
+					if currentScore > 0 {
+						currentScore--
+					}
+					continue
+				}
+				currentHits++
 				// Each right note increases the score by ten.
-				currentScore += 10
+				points := 10
+				mark := 'X'
+				if status == NOTE_SHARP || status == NOTE_FLAT {
+					// The right fingering, but out of tune, it only gets half.
+					points /= 2
+					mark = '~'
+					currentOutOfTune++
+				}
+				if (rune == 'S' || rune == 'D') && !tongued {
+					// The start of the note wasn't tongued, it only gets half,
+					// 2 points when it's also sharp or flat.
+					points /= 2
+					mark = 'x'
+					currentNotTongued++
+				}
+				screenBuffer[i][10] = mark
+				currentScore += points
 			//}
 
 		}else{
 			underRune := screenBuffer[i][10]
-			if underRune == 'S' || underRune == '_' || underRune == 'D'{
+			if note == UNCERTAIN && (underRune == 'S' || underRune == '_' || underRune == 'D') {
+				// Not sure of what was played, neither a hit nor a miss.
+				screenBuffer[i][10] = '?'
+				currentUncertain++
+			}else if note == SQUEAK && (underRune == 'S' || underRune == '_' || underRune == 'D') {
+				// The flute squeaked, a miss of its own.
+				screenBuffer[i][10] = '!'
+				currentMisses++
+				currentSqueaks++
+				if currentScore > 0 {
+					currentScore--
+				}
+			}else if underRune == 'S' || underRune == '_' || underRune == 'D'{
 				screenBuffer[i][10] = '@'
+				currentMisses++
 				if currentScore > 0 {
 					// Each wrong note decreases the score by one.
 					currentScore--
@@ -842,6 +1493,31 @@ func (MS *MusicScore) MSPrintMusicSheetToScreenBuffer(note int) {
 
 }
 
+// Returns the rune that starts the note under the score line in this line of
+// the sheet, 'S' or 'D', so a '_' continuation knows to which note it belongs.
+func (MS *MusicScore) MSNoteStartRuneUnderLine(line int) rune {
+	for j := MS.indexSourceStart; j >= 0 && j < MS.duration; j-- {
+		if startRune := MS.expandedRunesArray[line][j]; startRune != '_' {
+			return startRune
+		}
+	}
+	return '.'
+}
+
+// Returns true if a note of the score is under the score line, to be played in this step.
+func (MS *MusicScore) MSNoteUnderLine() bool {
+	if MS.indexTargetStart != 10 || MS.indexSourceStart >= MS.duration {
+		return false
+	}
+	for i:=3; i<NUM_LINES_SCREEN - 3; i++ {
+		rune := MS.expandedRunesArray[i][MS.indexSourceStart]
+		if rune == 'S' || rune == '_' || rune == 'D' {
+			return true
+		}
+	}
+	return false
+}
+
 func (MS *MusicScore) MSUpdateMovement() {
 
 	if MS.indexTargetStart > 10 {
@@ -880,6 +1556,13 @@ const MAX_SCREEN_WIDE int  = 40
 // Screen buffer where all text is written, before display.
 var screenBuffer [NUM_LINES_SCREEN][MAX_SCREEN_WIDE]rune = [NUM_LINES_SCREEN][MAX_SCREEN_WIDE]rune{}
 var currentScore int = 0
+var currentHits int = 0    // Number of notes played right on the score line.
+var currentMisses int = 0  // Number of notes missed or played wrong on the score line.
+var currentUncertain int = 0  // Number of notes on the score line played with an UNCERTAIN pitch.
+var currentNotTongued int = 0  // Number of notes hit at the start without an onset.
+var currentOutOfTune int = 0  // Number of notes hit sharp or flat.
+var currentSqueaks int = 0  // Number of notes on the score line missed with a SQUEAK.
+var currentBadlyOutOfTune int = 0  // Number of notes missed more than NOTE_MAX_CENTS out of tune.
 
 func printScreenBuffer(){
 
@@ -893,7 +1576,46 @@ func printScreenBuffer(){
 	}
 
 	myStr := string(runeArray)
-	fmt.Printf("\n\n\n\n                Galileu's Flute\n\n                    Score: %d\n%s", currentScore, myStr)
+	fmt.Printf("\n\n\n\n                Galileu's Flute\n\n                    Score: %d\n", currentScore)
+	if inputMeter != nil {
+		fmt.Printf("%s\n", inputMeter.String())
+	}
+	fmt.Printf("%s", myStr)
+	printDroppedAudio()
+}
+
+// Warns when the audio pipeline fell behind and lost audio.
+func printDroppedAudio() {
+	overrun := atomic.LoadUint64(&overrunSamples)
+	dropped := atomic.LoadUint64(&droppedFrames)
+	if overrun > 0 || dropped > 0 {
+		fmt.Printf(" Falling behind! Lost %d samples and %d frames.\n", overrun, dropped)
+	}
+}
+
+// Prints the score at the end of the game, with the percentage of notes hit.
+func printFinalScore() {
+	percentage := 0.0
+	if currentHits + currentMisses > 0 {
+		percentage = 100 * float64(currentHits) / float64(currentHits + currentMisses)
+	}
+	fmt.Printf("\n\n    Final Score: %d\n    Notes hit: %d of %d (%.1f%%)\n", currentScore, currentHits, currentHits + currentMisses, percentage)
+	if currentUncertain > 0 {
+		fmt.Printf("    Uncertain, not counted: %d\n", currentUncertain)
+	}
+	if currentNotTongued > 0 {
+		fmt.Printf("    Started without the tongue: %d\n", currentNotTongued)
+	}
+	if currentOutOfTune > 0 {
+		fmt.Printf("    Out of tune: %d\n", currentOutOfTune)
+	}
+	if currentBadlyOutOfTune > 0 {
+		fmt.Printf("    Badly out of tune, missed: %d\n", currentBadlyOutOfTune)
+	}
+	if currentSqueaks > 0 {
+		fmt.Printf("    Squeaks, overblown: %d\n", currentSqueaks)
+	}
+	printDroppedAudio()
 }
 
 
@@ -1073,6 +1795,60 @@ To execute do on a command line:
       galileu_flute.exe ./music_02.json
    or reading from a specific simplified ABC file format (extension .ABC or .abc)
       galileu_flute.exe ./music_01.ABC
+   or replaying a recorded WAV file instead of the microphone
+      galileu_flute.exe --input ./recording.wav ./music_01.json
+   (add --speed 0 to replay it as fast as possible)
+   or without a sound card, from raw PCM on stdin (s16le or f32le, any rate)
+      arecord -f S16_LE -r 44100 -c 1 | galileu_flute.exe --input - --speed 0 ./music_01.json
+      ffmpeg -i song.mp3 -f f32le -ar 48000 -ac 2 - | galileu_flute.exe --input - \
+            --pcm-format f32le --pcm-rate 48000 --pcm-channels 2 ./music_01.json
+   (a live capture like arecord already comes in real time, --speed 0 reads it as it arrives)
+   or from a synthetic test tone at a frequency in Hz
+      galileu_flute.exe --input tone:985 ./music_01.json
+   or from a synthetic recorder that plays the music score perfectly
+      galileu_flute.exe --input synth --speed 0 ./music_01.json
+   (--synth-vibrato and --synth-detune, in cents, make it less perfect)
+   (--synth-rate generates it at another sample rate, like 48000)
+   or listing the audio devices, to choose the microphone by number or name
+      galileu_flute.exe list-devices
+      galileu_flute.exe --device "USB" ./music_01.json
+   (the chosen device is saved in galileu_flute_config.json for the next run)
+   or hearing yourself in the headphones while playing
+      galileu_flute.exe --monitor --monitor-gain 0.5 ./music_01.json
+   or changing the pitch analysis windows, length and hop in samples
+      galileu_flute.exe --window 2048 --hop 512 ./music_01.json
+   (short windows answer faster, they must hold two periods of the lowest note, 206 samples at 44100 Hz)
+   or choosing the pitch detection algorithm, yin (default), mpm, acf or hps
+      galileu_flute.exe --pitch mpm ./music_01.json
+   (the chosen algorithm is saved in galileu_flute_config.json for the next runs, --pitch yin goes back to the default)
+   or asking for a more certain pitch, from 0 to 1, less certain notes show ? and do not count
+      galileu_flute.exe --min-confidence 0.95 ./music_01.json
+   (by default 0.9 with yin and 0.8 with mpm, acf and hps, their confidences aren't on the same scale)
+   or steadier notes, a longer median filter, more hysteresis in cents and a longer shortest note
+      galileu_flute.exe --median-frames 7 --note-hysteresis 40 --min-note 50ms ./music_01.json
+   or tuning the notes to another reference pitch, 440 Hz by default, like 415, 442 or 443
+      galileu_flute.exe --a4 442 ./music_01.json
+   or playing an alto recorder, or a sopranino or tenor, with the fingerings of the soprano songs
+      galileu_flute.exe --instrument alto ./music_01.json
+   (both are saved in galileu_flute_config.json for the next runs, --a4 440 --instrument soprano go back to the defaults)
+   or asking for a more precise pitch, the cents from a note that are in tune, 25 by default
+      galileu_flute.exe --tolerance 15 ./music_01.json
+   (a note played sharp or flat gets half the points, from 50 to 100 cents away it's * and a miss)
+   or calibrating the notes of your recorder, holding each note from DO to DO_HIGH
+      galileu_flute.exe calibrate
+   (the notes are saved in galileu_flute_profile.json and used by the game, --profile chooses another file)
+   or recording the session, the audio and a .json file of what the game heard
+      galileu_flute.exe --record ./session.wav ./music_01.json
+   or in a noisy room, raising the noise gate, quieter sound is silence
+      galileu_flute.exe --gate -40 --gate-attack 20ms --gate-release 100ms ./music_01.json
+   or measuring the input latency, playing a short note on every click, with headphones
+      galileu_flute.exe calibrate-latency
+   (it is saved in galileu_flute_config.json, --latency 120 sets it in milliseconds)
+   or measuring the accuracy of the pitch detectors on WAV files with Audacity label files (.txt)
+      galileu_flute.exe bench-pitch ./corpus
+   (without a directory it uses synthesized recorder tones, --pitch mpm measures only one)
+   or learning to hold a steady note in tune, a chromatic tuner without the music score
+      galileu_flute.exe tune
 
 
 Example of the output:
@@ -1081,6 +1857,7 @@ Example of the output:
          Galileu's Flute
 
              Score: 156
+ Level [#############.......]  -21 dB
   ---
  | = |
  |   |
@@ -1108,6 +1885,13 @@ Example of the output:
  At the Score Line:
    'X' - You hit the correct note, 10 point's.
    '@' - You hit the wrong note, -1 point.
+   '?' - The note wasn't clear enough to judge, no points.
+   'x' - You hit the note, but didn't start it with the tongue, 5 point's.
+   '~' - You hit the note, but sharp or flat, 5 point's.
+         Both, not tongued and sharp or flat, it shows 'x' with 2 point's.
+   '*' - The right note, but more than 50 cents out of tune, you lose 1 point.
+   '!' - The flute squeaked, blown too hard, you lose 1 point.
+   'v' - Above the line, you started a note.
    '|' - Just the indication of the line.
 
   To Exit the program:
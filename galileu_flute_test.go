@@ -0,0 +1,65 @@
+// Tests of the command line of the game.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestParseArguments(t *testing.T) {
+	tests := []struct {
+		commandLine string
+		arguments   []string
+		pitch       string
+		profile     string
+	}{
+		{"", []string{}, "", "default.json"},
+		{"./music_01.json", []string{"./music_01.json"}, "", "default.json"},
+		{"--pitch mpm ./music_01.json", []string{"./music_01.json"}, "mpm", "default.json"},
+		{"./music_01.json --pitch mpm", []string{"./music_01.json"}, "mpm", "default.json"},
+		{"bench-pitch --pitch mpm", []string{"bench-pitch"}, "mpm", "default.json"},
+		{"bench-pitch ./corpus --pitch mpm", []string{"bench-pitch", "./corpus"}, "mpm", "default.json"},
+		{"--pitch acf bench-pitch --pitch=hps ./corpus", []string{"bench-pitch", "./corpus"}, "hps", "default.json"},
+		{"calibrate --profile alto.json", []string{"calibrate"}, "", "alto.json"},
+		{"--profile alto.json calibrate --pitch mpm", []string{"calibrate"}, "mpm", "alto.json"},
+		{"calibrate --profile= --pitch yin", []string{"calibrate"}, "yin", ""},
+	}
+	for _, test := range tests {
+		flags := flag.NewFlagSet("galileu_flute", flag.ContinueOnError)
+		pitch := flags.String("pitch", "", "")
+		profile := flags.String("profile", "default.json", "")
+		arguments, err := parseArguments(flags, strings.Fields(test.commandLine))
+		if err != nil {
+			t.Errorf("%q: %v", test.commandLine, err)
+			continue
+		}
+		if strings.Join(arguments, " ") != strings.Join(test.arguments, " ") || len(arguments) != len(test.arguments) {
+			t.Errorf("%q: arguments %q, want %q", test.commandLine, arguments, test.arguments)
+		}
+		if *pitch != test.pitch || *profile != test.profile {
+			t.Errorf("%q: --pitch %q --profile %q, want %q and %q", test.commandLine, *pitch, *profile, test.pitch, test.profile)
+		}
+	}
+
+	flags := flag.NewFlagSet("galileu_flute", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	if _, err := parseArguments(flags, []string{"bench-pitch", "--pitch"}); err == nil {
+		t.Errorf("a flag without its value after the command, want an error")
+	}
+}
+
+func TestParseArgumentsOfTune(t *testing.T) {
+	flags := flag.NewFlagSet("galileu_flute", flag.ContinueOnError)
+	a4Frequency := flags.Float64("a4", 0, "")
+	instrument := flags.String("instrument", "", "")
+	arguments, err := parseArguments(flags, []string{"tune", "--instrument", "alto", "--a4", "442"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arguments) != 1 || arguments[0] != "tune" || *instrument != "alto" || *a4Frequency != 442 {
+		t.Errorf("arguments %q, --instrument %q, --a4 %g, want tune, alto and 442", arguments, *instrument, *a4Frequency)
+	}
+}
@@ -0,0 +1,149 @@
+// Input latency calibration.
+//
+// The sound of the flute reaches the game late, the sound card captures it in
+// blocks and the analysis needs a whole window of it. A player who plays right
+// when a note reaches the score line is heard a little later, when the next
+// note may already be there. The calibrate-latency command measures that delay:
+// it plays a click on a steady beat and the player plays a short note on every
+// click. The delay from each click to the note being detected, by the same
+// analysis the game uses, is measured and the median is saved in the
+// configuration. The game clock then judges each step with the audio that
+// arrived that much later.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+const (
+	CALIBRATION_CLICKS         int           = 16 // Clicks played, the count in included.
+	CALIBRATION_COUNT_IN       int           = 4  // First clicks, only to catch the beat, they aren't measured.
+	CALIBRATION_CLICK_INTERVAL time.Duration = 750 * time.Millisecond
+	CALIBRATION_CLICK_SECONDS  float64       = 0.02   // Duration of the click sound.
+	CALIBRATION_CLICK_HZ       float64       = 1500.0 // Frequency of the click sound.
+)
+
+// Delay of the input, from playing a note to the game detecting it.
+type latencyCalibrator struct {
+	sampleRate    float64
+	clickInterval int64 // Samples between two clicks.
+	clickLen      int64
+	outputPos     int64 // Samples written to the output.
+	ring          *sampleRingBuffer
+	analyzer      *slidingWindowAnalyzer
+	wasSilent     bool
+	onsets        []int64 // Sample times where the sound started.
+}
+
+func newLatencyCalibrator(sampleRate float64, options AnalysisOptions) *latencyCalibrator {
+	return &latencyCalibrator{
+		sampleRate:    sampleRate,
+		clickInterval: int64(CALIBRATION_CLICK_INTERVAL.Seconds() * sampleRate),
+		clickLen:      int64(CALIBRATION_CLICK_SECONDS * sampleRate),
+		ring:          newSampleRingBuffer(int(RING_BUFFER_SECONDS * sampleRate)),
+		analyzer:      newSlidingWindowAnalyzer(sampleRate, options),
+		wasSilent:     true,
+	}
+}
+
+// Total samples of the calibration, the clicks and one interval after the last.
+func (C *latencyCalibrator) totalLen() int64 {
+	return int64(CALIBRATION_CLICKS+1) * C.clickInterval
+}
+
+// The audio callback, plays the clicks and keeps the input for Analyse. The
+// input and the output of a callback are the same moment of the stream, so
+// the delay measured includes the output and the input latency.
+func (C *latencyCalibrator) processAudio(in, out []float32) {
+	for i := range out {
+		out[i] = 0
+		clickPos := C.outputPos % C.clickInterval
+		if C.outputPos < int64(CALIBRATION_CLICKS)*C.clickInterval && clickPos < C.clickLen {
+			t := float64(clickPos) / C.sampleRate
+			decay := 1 - float64(clickPos)/float64(C.clickLen)
+			out[i] = float32(0.5 * decay * math.Sin(2*math.Pi*CALIBRATION_CLICK_HZ*t))
+		}
+		C.outputPos++
+	}
+	C.ring.PushMono(in, 1)
+}
+
+// Analyses the input received so far, returns true when the calibration ended.
+func (C *latencyCalibrator) Analyse() bool {
+	block := make([]float32, SOURCE_BLOCK_LEN)
+	for {
+		n := C.ring.Pop(block)
+		if n == 0 {
+			break
+		}
+		for _, sample := range block[:n] {
+			frame, ok := C.analyzer.Push(sample)
+			if !ok {
+				continue
+			}
+			if C.wasSilent && !frame.silent {
+				C.onsets = append(C.onsets, frame.sampleTime)
+			}
+			C.wasSilent = frame.silent
+		}
+	}
+	return C.analyzer.samplesSeen >= C.totalLen()
+}
+
+// Returns the median delay from the measured clicks to the nearest note.
+func (C *latencyCalibrator) Result() (time.Duration, error) {
+	delays := []float64{}
+	for _, onset := range C.onsets {
+		// The note belongs to the nearest click, a note half an interval away isn't a reply to any.
+		click := int64(math.Round(float64(onset) / float64(C.clickInterval)))
+		if click < int64(CALIBRATION_COUNT_IN) || click >= int64(CALIBRATION_CLICKS) {
+			continue
+		}
+		delays = append(delays, float64(onset-click*C.clickInterval)/C.sampleRate)
+	}
+	measured := CALIBRATION_CLICKS - CALIBRATION_COUNT_IN
+	if len(delays) < measured/2 {
+		return 0, fmt.Errorf("only %d of the %d notes were heard, check the microphone and the --gate", len(delays), measured)
+	}
+	latency := math.Max(0, median(delays))
+	return time.Duration(latency * float64(time.Second)), nil
+}
+
+// Plays the clicks on the output of the host API of the input device and
+// measures the latency, see latencyCalibrator.
+func calibrateLatency(deviceSelector string, options AnalysisOptions) (time.Duration, error) {
+	device, err := findInputDevice(deviceSelector)
+	if err != nil {
+		return 0, err
+	}
+	outputDevice := device.HostApi.DefaultOutputDevice
+	if outputDevice == nil {
+		return 0, fmt.Errorf("the host API %s has no output device for the clicks", device.HostApi.Name)
+	}
+	p := portaudio.LowLatencyParameters(device, outputDevice)
+	p.Input.Channels = 1
+	p.Output.Channels = 1
+	C := newLatencyCalibrator(p.SampleRate, options)
+	stream, err := portaudio.OpenStream(p, C.processAudio)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	fmt.Printf("\n With headphones, play a short note on every click, the first %d clicks are only to catch the beat.\n", CALIBRATION_COUNT_IN)
+	if err := stream.Start(); err != nil {
+		return 0, err
+	}
+	for !C.Analyse() {
+		time.Sleep(PIPELINE_POLL_INTERVAL)
+	}
+	if err := stream.Stop(); err != nil {
+		return 0, err
+	}
+	return C.Result()
+}
@@ -0,0 +1,108 @@
+// Input level meter.
+//
+// When the game doesn't hear the player it's almost always the gain of the
+// microphone, so the header shows the level of the input in every step, with
+// the noise gate threshold marked in the bar, a warning when the input clips
+// and another when it stays too quiet to be detected. The player is too quiet
+// when there is sound above the noise of the room that stays under the gate,
+// or when the score has notes to play and nothing is heard, not in the rests.
+//
+//	Level [##########|.........]  -32 dB  CLIP!
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Range in dBFS shown by the level bar, and its width in characters.
+const LEVEL_METER_MIN_DB float64 = -60
+const LEVEL_METER_WIDTH int = 20
+
+// Peak level in dBFS from which the input is clipping.
+const LEVEL_CLIP_DB float64 = -0.1
+
+// How long the clipping warning stays on after the last clip.
+const LEVEL_CLIP_HOLD_SECONDS float64 = 2.0
+
+// How long the input must stay too quiet for the warning.
+const LEVEL_QUIET_SECONDS float64 = 5.0
+
+// Level above the noise floor that is some sound, played too quietly when under the gate.
+const LEVEL_SOUND_ABOVE_FLOOR_DB float64 = 10
+
+// How fast the noise floor rises to a louder room, it drops at once to a quieter one.
+const LEVEL_FLOOR_RISE_DB_PER_SECOND float64 = 1.0
+
+// The meter of the game, nil until the analysis is set up. Used only by the render goroutine.
+var inputMeter *levelMeter = nil
+
+type levelMeter struct {
+	gateThresholdDB float64
+	rmsDB           float64 // Level of the last step.
+	clipHoldSteps   int     // Steps the clipping warning still stays on.
+	quietSteps      int     // Steps in a row too quiet, see Update.
+	noiseFloorDB    float64 // Level of the noise of the room, the quietest recent level.
+}
+
+func newLevelMeter(gateThresholdDB float64) *levelMeter {
+	return &levelMeter{gateThresholdDB: gateThresholdDB, rmsDB: LEVEL_FLOOR_DB, noiseFloorDB: math.Inf(1)}
+}
+
+// Steps of the game in a number of seconds, at least one.
+func gameStepsIn(seconds float64) int {
+	return int(math.Max(1, math.Ceil(seconds/GAME_STEP_SECONDS)))
+}
+
+// Updates the meter with the levels of a game step, noteExpected is true
+// when the score has a note to play in it.
+func (L *levelMeter) Update(frame AnalysisFrame, noteExpected bool) {
+	L.rmsDB = frame.rmsDB
+	if frame.peakDB >= LEVEL_CLIP_DB {
+		L.clipHoldSteps = gameStepsIn(LEVEL_CLIP_HOLD_SECONDS)
+	} else if L.clipHoldSteps > 0 {
+		L.clipHoldSteps--
+	}
+	L.noiseFloorDB = math.Min(frame.rmsDB, L.noiseFloorDB+LEVEL_FLOOR_RISE_DB_PER_SECOND*GAME_STEP_SECONDS)
+	sound := frame.rmsDB >= L.noiseFloorDB+LEVEL_SOUND_ABOVE_FLOOR_DB
+	if frame.rmsDB < L.gateThresholdDB && (sound || noteExpected) {
+		L.quietSteps++
+	} else {
+		L.quietSteps = 0
+	}
+}
+
+// Column of the bar for a level.
+func (L *levelMeter) column(levelDB float64) int {
+	fraction := (levelDB - LEVEL_METER_MIN_DB) / -LEVEL_METER_MIN_DB
+	return int(math.Round(math.Max(0, math.Min(1, fraction)) * float64(LEVEL_METER_WIDTH)))
+}
+
+// The level bar, with the warnings after it.
+func (L *levelMeter) String() string {
+	filled := L.column(L.rmsDB)
+	gate := L.column(L.gateThresholdDB)
+
+	var bar strings.Builder
+	for i := 0; i < LEVEL_METER_WIDTH; i++ {
+		switch {
+		case i < filled:
+			bar.WriteRune('#')
+		case i == gate && L.gateThresholdDB > LEVEL_METER_MIN_DB:
+			bar.WriteRune('|')
+		default:
+			bar.WriteRune('.')
+		}
+	}
+
+	line := fmt.Sprintf(" Level [%s] %4.0f dB", bar.String(), math.Max(L.rmsDB, LEVEL_METER_MIN_DB))
+	if L.clipHoldSteps > 0 {
+		line += "  CLIP! Lower the mic gain."
+	}
+	if L.quietSteps >= gameStepsIn(LEVEL_QUIET_SECONDS) {
+		line += "  Too quiet! Raise the mic gain."
+	}
+	return line
+}
@@ -0,0 +1,110 @@
+// Input level and noise gate.
+//
+// YIN finds a pitch in almost anything, the hiss of the room or the fan of the
+// computer included, and the game used to turn that into the note with no
+// holes covered. The level of every analysis window is measured first and a
+// noise gate decides if the player is blowing at all. While the gate is closed
+// the window is silence, it isn't given to the pitch detector.
+//
+// The gate opens when the level stays above the threshold for the attack time
+// and closes when it stays below the threshold, minus a small hysteresis, for
+// the release time, so short clicks don't open it and the end of a note or a
+// breath in the middle of it doesn't close it.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Default noise gate settings.
+const DEFAULT_GATE_THRESHOLD_DB float64 = -50
+const DEFAULT_GATE_ATTACK time.Duration = 10 * time.Millisecond
+const DEFAULT_GATE_RELEASE time.Duration = 80 * time.Millisecond
+
+// How far below the threshold the level must fall for the gate to close.
+const GATE_HYSTERESIS_DB float64 = 6
+
+// Level in dBFS given to digital silence, instead of minus infinity.
+const LEVEL_FLOOR_DB float64 = -120
+
+// Converts an amplitude, 1 is full scale, to dBFS.
+func amplitudeToDB(amplitude float64) float64 {
+	if amplitude <= 0 {
+		return LEVEL_FLOOR_DB
+	}
+	return math.Max(20*math.Log10(amplitude), LEVEL_FLOOR_DB)
+}
+
+// Returns the RMS and the peak level in dBFS of a window of samples.
+func measureLevel(window []float32) (rmsDB float64, peakDB float64) {
+	sumSquares := 0.0
+	peak := 0.0
+	for _, sample := range window {
+		value := float64(sample)
+		sumSquares += value * value
+		peak = math.Max(peak, math.Abs(value))
+	}
+	if len(window) == 0 {
+		return LEVEL_FLOOR_DB, LEVEL_FLOOR_DB
+	}
+	return amplitudeToDB(math.Sqrt(sumSquares / float64(len(window)))), amplitudeToDB(peak)
+}
+
+// Checks the noise gate settings given in the command line.
+func validateNoiseGate(thresholdDB float64, attack time.Duration, release time.Duration) error {
+	if thresholdDB > 0 {
+		return fmt.Errorf("the gate threshold is in dBFS and can't be above 0, it is %g", thresholdDB)
+	}
+	if attack < 0 || release < 0 {
+		return fmt.Errorf("the gate attack and release can't be negative, they are %v and %v", attack, release)
+	}
+	return nil
+}
+
+type noiseGate struct {
+	thresholdDB    float64 // Below this RMS level the input is silence, -120 disables the gate.
+	attackSeconds  float64
+	releaseSeconds float64
+	open           bool
+	timeAbove      float64 // Seconds the level has been above the threshold.
+	timeBelow      float64 // Seconds the level has been below the closing threshold.
+}
+
+func newNoiseGate(thresholdDB float64, attack time.Duration, release time.Duration) *noiseGate {
+	return &noiseGate{
+		thresholdDB:    thresholdDB,
+		attackSeconds:  attack.Seconds(),
+		releaseSeconds: release.Seconds(),
+	}
+}
+
+// Gives the gate the level of the next window, that came frameSeconds after
+// the previous one, returns true if the gate is open.
+func (G *noiseGate) Process(rmsDB float64, frameSeconds float64) bool {
+	if G.thresholdDB <= LEVEL_FLOOR_DB {
+		return true
+	}
+
+	if rmsDB >= G.thresholdDB {
+		G.timeAbove += frameSeconds
+	} else {
+		G.timeAbove = 0
+	}
+	if rmsDB < G.thresholdDB-GATE_HYSTERESIS_DB {
+		G.timeBelow += frameSeconds
+	} else {
+		G.timeBelow = 0
+	}
+
+	if !G.open && rmsDB >= G.thresholdDB && G.timeAbove >= G.attackSeconds {
+		G.open = true
+		G.timeBelow = 0
+	}
+	if G.open && G.timeBelow >= G.releaseSeconds && G.timeBelow > 0 {
+		G.open = false
+	}
+	return G.open
+}
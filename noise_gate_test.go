@@ -0,0 +1,77 @@
+// Tests of the input level and the noise gate.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNoiseGate(t *testing.T) {
+	const frameSeconds = 0.01
+	tests := []struct {
+		name        string
+		thresholdDB float64
+		attack      time.Duration
+		release     time.Duration
+		levels      []float64 // RMS level of each frame in dBFS.
+		want        string    // '#' where the gate is open, '.' where closed.
+	}{
+		{"quiet", -50, 20 * time.Millisecond, 30 * time.Millisecond,
+			[]float64{-70, -70, -60, -51}, "...."},
+		{"loud", -50, 20 * time.Millisecond, 30 * time.Millisecond,
+			[]float64{-30, -30, -30, -30}, ".###"},
+		{"on the threshold", -50, 20 * time.Millisecond, 30 * time.Millisecond,
+			[]float64{-50, -50, -50}, ".##"},
+		{"no attack", -50, 0, 30 * time.Millisecond,
+			[]float64{-30, -30}, "##"},
+		{"clicks", -50, 20 * time.Millisecond, 30 * time.Millisecond,
+			[]float64{-30, -70, -30, -70, -30, -70}, "......"},
+		{"held in the hysteresis", -50, 20 * time.Millisecond, 30 * time.Millisecond,
+			[]float64{-30, -30, -52, -55, -52, -55, -55}, ".######"},
+		{"released", -50, 20 * time.Millisecond, 30 * time.Millisecond,
+			[]float64{-30, -30, -60, -60, -60, -60}, ".###.."},
+		{"breath shorter than the release", -50, 20 * time.Millisecond, 30 * time.Millisecond,
+			[]float64{-30, -30, -60, -60, -30, -60, -60, -30}, ".#######"},
+		{"decaying", -50, 20 * time.Millisecond, 30 * time.Millisecond,
+			[]float64{-30, -40, -48, -53, -57, -61, -65, -70}, ".#####.."},
+		{"opens again", -50, 20 * time.Millisecond, 10 * time.Millisecond,
+			[]float64{-30, -30, -70, -30, -30}, ".#..#"},
+		{"disabled", LEVEL_FLOOR_DB, 20 * time.Millisecond, 30 * time.Millisecond,
+			[]float64{LEVEL_FLOOR_DB, -70, LEVEL_FLOOR_DB}, "###"},
+	}
+	for _, test := range tests {
+		gate := newNoiseGate(test.thresholdDB, test.attack, test.release)
+		got := []byte{}
+		for _, level := range test.levels {
+			if gate.Process(level, frameSeconds) {
+				got = append(got, '#')
+			} else {
+				got = append(got, '.')
+			}
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: gate %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMeasureLevel(t *testing.T) {
+	tests := []struct {
+		window []float32
+		rmsDB  float64
+		peakDB float64
+	}{
+		{[]float32{}, LEVEL_FLOOR_DB, LEVEL_FLOOR_DB},
+		{[]float32{0, 0, 0, 0}, LEVEL_FLOOR_DB, LEVEL_FLOOR_DB},
+		{[]float32{1, -1, 1, -1}, 0, 0},
+		{[]float32{0.5, -0.5, 0, 0}, -9.03, -6.02},
+		{[]float32{0.1, -0.1, 0.1, -0.1}, -20, -20},
+	}
+	for _, test := range tests {
+		rmsDB, peakDB := measureLevel(test.window)
+		if rmsDB < test.rmsDB-0.01 || rmsDB > test.rmsDB+0.01 || peakDB < test.peakDB-0.01 || peakDB > test.peakDB+0.01 {
+			t.Errorf("measureLevel(%v) = %.2f dB and %.2f dB, want %.2f dB and %.2f dB", test.window, rmsDB, peakDB, test.rmsDB, test.peakDB)
+		}
+	}
+}
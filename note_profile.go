@@ -0,0 +1,242 @@
+// Note calibration of a player.
+//
+// Every recorder and every player is a little different, the notes come out
+// a few cents away from equal temperament and some waver more than others.
+// The calibrate command asks the player to hold each note, from DO to
+// DO_HIGH, measures the median frequency and its spread, and writes them to a
+// profile:
+//
+//	galileu_flute.exe calibrate
+//
+// At the start of the game the profile, when there is one, replaces the
+// frequencies of the tuning and each note is in tune inside its tolerance,
+// three times its spread but not less than PROFILE_MIN_TOLERANCE_CENTS nor
+// more than PROFILE_MAX_TOLERANCE_CENTS, in place of --tolerance. --profile
+// chooses the file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+const DEFAULT_PROFILE_PATH_AND_NAME string = "./galileu_flute_profile.json"
+
+const (
+	PROFILE_STEP_SECONDS        float64 = 0.1 // Time between two measures of the held note.
+	PROFILE_NOTE_SECONDS        float64 = 3.0 // Time each note must be held.
+	PROFILE_SEARCH_CENTS        float64 = 80  // Farthest from the tuning a held note is measured.
+	PROFILE_SPREAD_TOLERANCES   float64 = 3   // Tolerance of a note, in spreads.
+	PROFILE_MIN_TOLERANCE_CENTS float64 = 20
+	PROFILE_MAX_TOLERANCE_CENTS float64 = 40 // Below NOTE_MAX_CENTS, so a note can still be sharp or flat.
+	PROFILE_PROGRESS_WIDTH      int     = 20 // Characters of the progress bar.
+)
+
+// Measure of one note of the flute.
+type NoteProfileEntry struct {
+	Note           string  `json:"note"`           // Name of the note, see fluteNoteNames.
+	Frequency      float64 `json:"frequency"`      // Median frequency in Hz.
+	SpreadCents    float64 `json:"spreadCents"`    // Spread of the frequency, a robust standard deviation.
+	ToleranceCents float64 `json:"toleranceCents"` // Cents around the frequency that are in tune.
+	Steps          int     `json:"steps"`          // Number of measures.
+}
+
+type NoteProfile struct {
+	Created     time.Time          `json:"created"`
+	A4Frequency float64            `json:"a4Frequency"` // Tuning during the calibration.
+	Instrument  string             `json:"instrument"`
+	Detector    string             `json:"detector"`
+	Notes       []NoteProfileEntry `json:"notes"`
+}
+
+// Reads a profile, nil without error when the file doesn't exist.
+func loadNoteProfile(profilePathAndName string) (*NoteProfile, error) {
+	raw, err := ioutil.ReadFile(profilePathAndName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	profile := &NoteProfile{}
+	if err := json.Unmarshal(raw, profile); err != nil {
+		return nil, err
+	}
+	for _, entry := range profile.Notes {
+		if noteIndexByName(entry.Note) < 0 || entry.Frequency <= 0 || entry.ToleranceCents <= 0 {
+			return nil, fmt.Errorf("the note %q of the profile isn't valid", entry.Note)
+		}
+	}
+	return profile, nil
+}
+
+func saveNoteProfile(profilePathAndName string, profile *NoteProfile) error {
+	raw, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(profilePathAndName, append(raw, '\n'), 0644)
+}
+
+// Index of a flute note from its name, -1 if there is none.
+func noteIndexByName(name string) int {
+	for i, noteName := range fluteNoteNames {
+		if strings.EqualFold(name, noteName) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Replaces the frequencies and tolerances of the notes with the ones of a profile.
+func (MN *MusicNote) MNApplyProfile(profile *NoteProfile) {
+	for _, entry := range profile.Notes {
+		i := noteIndexByName(entry.Note)
+		MN.frequency[i] = entry.Frequency
+		MN.tolerance[i] = entry.ToleranceCents
+	}
+}
+
+//##################
+// The calibrate command.
+
+// The calibration, nil while playing the game.
+var noteCalibration *noteCalibrator = nil
+
+type noteCalibrator struct {
+	profile       NoteProfile
+	minConfidence float64   // Less confident pitches aren't measured.
+	note          int       // Note being measured, from DO to DO_HIGH.
+	cents         []float64 // Cents from the tuning of the measures of the note.
+	released      bool      // The player stopped after the last note, the next one can start.
+	stepsNote     int       // Measures needed for each note.
+	done          chan struct{}
+}
+
+func newNoteCalibrator(options AnalysisOptions) *noteCalibrator {
+	return &noteCalibrator{
+		profile: NoteProfile{
+			Created:     time.Now(),
+			A4Frequency: tuningA4Frequency,
+			Instrument:  tuningInstrument,
+			Detector:    options.PitchDetector,
+			Notes:       []NoteProfileEntry{},
+		},
+		minConfidence: options.MinConfidence,
+		note:          DO,
+		released:      true,
+		stepsNote:     int(math.Round(PROFILE_NOTE_SECONDS / PROFILE_STEP_SECONDS)),
+		done:          make(chan struct{}),
+	}
+}
+
+// Robust standard deviation of values, from the median absolute deviation.
+func robustSpread(values []float64) float64 {
+	center := median(values)
+	deviations := make([]float64, len(values))
+	for i, value := range values {
+		deviations[i] = math.Abs(value - center)
+	}
+	return 1.4826 * median(deviations)
+}
+
+// Takes the measure of one step, in the render goroutine.
+func (C *noteCalibrator) Render(frame AnalysisFrame) {
+	if C.note > DO_HIGH {
+		return
+	}
+	expected := musicNote.frequency[C.note]
+	// The pitch is measured against the expected note, not the note tracked,
+	// that is unknown farther than NOTE_MAX_CENTS from every note.
+	heard := frame.frequency > 0 && frame.confidence >= C.minConfidence && frame.note != SILENCE && frame.note != SQUEAK
+	if !C.released {
+		// Waits for a silence between two notes, so the last one isn't measured again.
+		C.released = frame.note == SILENCE
+	} else if heard && math.Abs(centsBetween(frame.frequency, expected)) <= PROFILE_SEARCH_CENTS {
+		C.cents = append(C.cents, centsBetween(frame.frequency, expected))
+	}
+
+	if len(C.cents) >= C.stepsNote {
+		centsMedian := median(C.cents)
+		spread := robustSpread(C.cents)
+		C.profile.Notes = append(C.profile.Notes, NoteProfileEntry{
+			Note:           fluteNoteNames[C.note],
+			Frequency:      expected * math.Pow(2, centsMedian/1200),
+			SpreadCents:    spread,
+			ToleranceCents: math.Max(PROFILE_MIN_TOLERANCE_CENTS, math.Min(PROFILE_MAX_TOLERANCE_CENTS, PROFILE_SPREAD_TOLERANCES*spread)),
+			Steps:          len(C.cents),
+		})
+		fmt.Printf("\n %s measured, %+.0f cents, spread %.1f cents.\n", fluteNoteNames[C.note], centsMedian, spread)
+		C.note++
+		C.cents = C.cents[:0]
+		C.released = false
+		if C.note > DO_HIGH {
+			close(C.done)
+			return
+		}
+	}
+
+	musicNote.MNPrintNoteToScreenBuffer(C.note)
+	progress := len(C.cents) * PROFILE_PROGRESS_WIDTH / C.stepsNote
+	fmt.Printf("\n\n\n\n                Galileu's Flute - Calibration\n\n")
+	if !C.released {
+		fmt.Printf(" Stop, take a breath, then hold %s.\n", fluteNoteNames[C.note])
+	} else {
+		fmt.Printf(" Hold %s steady [%s%s]\n", fluteNoteNames[C.note],
+			strings.Repeat("#", progress), strings.Repeat(".", PROFILE_PROGRESS_WIDTH-progress))
+	}
+	for i := 0; i < NUM_LINES_SCREEN; i++ {
+		fmt.Printf("%s\n", strings.TrimRight(string(screenBuffer[i][:10]), "\x00"))
+	}
+	printDroppedAudio()
+}
+
+// The calibrate command, measures every note and writes the profile.
+func runNoteCalibration(inputOptions AudioInputOptions, options AnalysisOptions, profilePathAndName string) error {
+	source, err := openAudioSource(inputOptions)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	if err := validateWindowForRange(options.WindowLen, source.SampleRate()); err != nil {
+		return err
+	}
+
+	calibration := newNoteCalibrator(options)
+	noteCalibration = calibration
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	// Measures the held note more often than the game steps.
+	options.StepSeconds = PROFILE_STEP_SECONDS
+	pipeline := newAudioPipeline(source, options)
+	pipeline.Start()
+	if err := source.Start(pipeline.FrameHandler()); err != nil {
+		return err
+	}
+	select {
+	case <-calibration.done:
+	case <-source.Done():
+	case <-interrupt:
+	}
+	if err := source.Stop(); err != nil {
+		return err
+	}
+	pipeline.Finish()
+	if err := source.Err(); err != nil {
+		return err
+	}
+
+	if len(calibration.profile.Notes) < DO_HIGH {
+		return fmt.Errorf("the calibration stopped after %d of %d notes, the profile wasn't written",
+			len(calibration.profile.Notes), DO_HIGH)
+	}
+	return saveNoteProfile(profilePathAndName, &calibration.profile)
+}
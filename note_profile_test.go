@@ -0,0 +1,152 @@
+// Tests of the note profile and of the calibration that measures it.
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadNoteProfile(t *testing.T) {
+	tests := []struct {
+		name  string
+		json  string // Not written if empty.
+		notes int    // Notes of the profile, -1 for an error.
+	}{
+		{"missing", "", 0},
+		{"valid", `{"notes": [{"note": "SOL", "frequency": 790, "toleranceCents": 20}, {"note": "DO_HIGH", "frequency": 1050, "toleranceCents": 40}]}`, 2},
+		{"any case", `{"notes": [{"note": "sol", "frequency": 790, "toleranceCents": 20}]}`, 1},
+		{"no notes", `{"instrument": "alto"}`, 0},
+		{"unknown note", `{"notes": [{"note": "FA#", "frequency": 740, "toleranceCents": 20}]}`, -1},
+		{"no frequency", `{"notes": [{"note": "SOL", "toleranceCents": 20}]}`, -1},
+		{"negative frequency", `{"notes": [{"note": "SOL", "frequency": -790, "toleranceCents": 20}]}`, -1},
+		{"no tolerance", `{"notes": [{"note": "SOL", "frequency": 790, "toleranceCents": 0}]}`, -1},
+		{"not json", `notes: SOL`, -1},
+	}
+	directory := t.TempDir()
+	for _, test := range tests {
+		path := filepath.Join(directory, test.name+".json")
+		if test.json != "" {
+			if err := ioutil.WriteFile(path, []byte(test.json), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		profile, err := loadNoteProfile(path)
+		switch {
+		case test.notes < 0:
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.json == "":
+			if profile != nil {
+				t.Errorf("%s: a profile without a file", test.name)
+			}
+		case len(profile.Notes) != test.notes:
+			t.Errorf("%s: %d notes, want %d", test.name, len(profile.Notes), test.notes)
+		}
+	}
+}
+
+func TestMNApplyProfile(t *testing.T) {
+	MN := MusicNote{}
+	MN.MNnew()
+	tuned := MN.frequency
+	MN.MNApplyProfile(&NoteProfile{Notes: []NoteProfileEntry{
+		{Note: "sol", Frequency: 790, ToleranceCents: 30},
+		{Note: "DO_HIGH", Frequency: 1050, ToleranceCents: 40},
+	}})
+	for note := 0; note < fluteNoteLen; note++ {
+		wantFrequency, wantTolerance := tuned[note], tuningToleranceCents
+		switch note {
+		case SOL:
+			wantFrequency, wantTolerance = 790, 30
+		case DO_HIGH:
+			wantFrequency, wantTolerance = 1050, 40
+		}
+		if MN.frequency[note] != wantFrequency || MN.tolerance[note] != wantTolerance {
+			t.Errorf("%s: %g Hz and %g cents, want %g Hz and %g cents", fluteNoteNames[note],
+				MN.frequency[note], MN.tolerance[note], wantFrequency, wantTolerance)
+		}
+	}
+	if _, status := MN.MNNoteStatus(SOL, 790*math.Pow(2, 29.0/1200)); status != NOTE_IN_TUNE {
+		t.Errorf("29 cents from the SOL of the profile is %s, want in tune", noteStatusNames[status])
+	}
+}
+
+// A step of the calibration with the note held cents away from the tuning.
+func heldStep(note int, cents float64, confidence float64) AnalysisFrame {
+	tracked := note
+	if math.Abs(cents) > NOTE_MAX_CENTS {
+		tracked = UNCERTAIN
+	}
+	return AnalysisFrame{note: tracked, frequency: musicNote.frequency[note] * math.Pow(2, cents/1200), confidence: confidence}
+}
+
+func silentStep() AnalysisFrame {
+	return AnalysisFrame{note: SILENCE, frequency: -1, silent: true}
+}
+
+func TestNoteCalibratorRender(t *testing.T) {
+	musicNote.MNnew()
+	tests := []struct {
+		name     string
+		steps    []AnalysisFrame
+		measured []float64 // Cents of the notes measured, from DO.
+		measures int       // Measures of the note being measured.
+		released bool
+	}{
+		{"holds DO", []AnalysisFrame{heldStep(DO, 10, 1), heldStep(DO, 10, 1)}, nil, 2, true},
+		{"measures DO", []AnalysisFrame{heldStep(DO, 10, 1), heldStep(DO, 12, 1), heldStep(DO, 8, 1)}, []float64{10}, 0, false},
+		{"far from the tuning", []AnalysisFrame{heldStep(DO, 70, 1), heldStep(DO, 70, 1), heldStep(DO, 70, 1)}, []float64{70}, 0, false},
+		{"outside the search", []AnalysisFrame{heldStep(DO, 90, 1), heldStep(DO, -90, 1), heldStep(RE, 0, 1)}, nil, 0, true},
+		{"not confident", []AnalysisFrame{heldStep(DO, 0, 0.5), heldStep(DO, 0, 0.5)}, nil, 0, true},
+		{"squeak", []AnalysisFrame{{note: SQUEAK, frequency: musicNote.frequency[DO], confidence: 1}}, nil, 0, true},
+		{"silence", []AnalysisFrame{silentStep(), silentStep()}, nil, 0, true},
+		{"DO held after it was measured", []AnalysisFrame{heldStep(DO, 0, 1), heldStep(DO, 0, 1), heldStep(DO, 0, 1),
+			heldStep(DO, 0, 1), heldStep(RE, 0, 1)}, []float64{0}, 0, false},
+		{"released before RE", []AnalysisFrame{heldStep(DO, 0, 1), heldStep(DO, 0, 1), heldStep(DO, 0, 1),
+			heldStep(DO, 0, 1), silentStep(), heldStep(RE, -20, 1)}, []float64{0}, 1, true},
+		{"measures RE", []AnalysisFrame{heldStep(DO, 0, 1), heldStep(DO, 0, 1), heldStep(DO, 0, 1),
+			silentStep(), heldStep(RE, -20, 1), heldStep(RE, -20, 1), heldStep(RE, -20, 1)}, []float64{0, -20}, 0, false},
+	}
+
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = devNull
+	defer func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}()
+
+	for _, test := range tests {
+		calibrator := newNoteCalibrator(AnalysisOptions{MinConfidence: defaultMinConfidence(DEFAULT_PITCH_DETECTOR)})
+		calibrator.stepsNote = 3
+		for _, step := range test.steps {
+			calibrator.Render(step)
+		}
+		if len(calibrator.profile.Notes) != len(test.measured) {
+			t.Errorf("%s: %d notes measured, want %d", test.name, len(calibrator.profile.Notes), len(test.measured))
+			continue
+		}
+		for i, entry := range calibrator.profile.Notes {
+			cents := centsBetween(entry.Frequency, musicNote.frequency[DO+i])
+			if entry.Note != fluteNoteNames[DO+i] || math.Abs(cents-test.measured[i]) > 0.01 {
+				t.Errorf("%s: %s measured %+.2f cents, want %s %+g cents", test.name, entry.Note, cents, fluteNoteNames[DO+i], test.measured[i])
+			}
+			if entry.ToleranceCents < PROFILE_MIN_TOLERANCE_CENTS || entry.ToleranceCents > PROFILE_MAX_TOLERANCE_CENTS {
+				t.Errorf("%s: %s tolerance %g cents", test.name, entry.Note, entry.ToleranceCents)
+			}
+		}
+		if len(calibrator.cents) != test.measures || calibrator.released != test.released {
+			t.Errorf("%s: %d measures, released %v, want %d and %v", test.name, len(calibrator.cents), calibrator.released, test.measures, test.released)
+		}
+	}
+}
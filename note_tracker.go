@@ -0,0 +1,129 @@
+// Note tracker.
+//
+// The note of a single analysis window flickers between neighbours during the
+// attack of a note and the change from one note to the next. The tracker turns
+// the frames into a stable current note:
+//   - a median filter over the frequencies of the last frames removes single
+//     wrong frames;
+//   - hysteresis keeps the current note until the frequency is clearly closer
+//     to another one, by more than the hysteresis in cents, but not farther
+//     than NOTE_MAX_CENTS from it, so it only matters between notes of a
+//     profile closer than twice NOTE_MAX_CENTS;
+//   - a new note, the silence included, only becomes current after it lasted
+//     the minimum note duration.
+//
+// The raw note and frequency of each frame are kept next to the tracked ones,
+// see the frames of a --record.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Default settings of the note tracker.
+const DEFAULT_MEDIAN_FRAMES int = 5
+const DEFAULT_NOTE_HYSTERESIS_CENTS float64 = 30
+const DEFAULT_MIN_NOTE_DURATION time.Duration = 30 * time.Millisecond
+
+// Checks the note tracker settings given in the command line.
+func validateNoteTracker(medianFrames int, hysteresisCents float64, minNoteDuration time.Duration) error {
+	if medianFrames < 1 {
+		return fmt.Errorf("the median filter needs at least 1 frame, it has %d", medianFrames)
+	}
+	if hysteresisCents < 0 || minNoteDuration < 0 {
+		return fmt.Errorf("the note hysteresis and minimum duration can't be negative, they are %g and %v", hysteresisCents, minNoteDuration)
+	}
+	return nil
+}
+
+// Distance in cents from frequency to reference, positive when it's higher.
+func centsBetween(frequency float64, reference float64) float64 {
+	return 1200 * math.Log2(frequency/reference)
+}
+
+type noteTracker struct {
+	minConfidence   float64
+	hysteresisCents float64
+	minNoteFrames   int
+	recent          []float64 // Frequencies of the last frames with a clear pitch, circular.
+	recentLen       int       // How many of recent are filled.
+	recentPos       int
+	current         int // The stable note.
+	candidate       int // The note that may become current.
+	candidateFrames int // Frames in a row the candidate lasted.
+}
+
+func newNoteTracker(options AnalysisOptions, sampleRate float64) *noteTracker {
+	hopSeconds := float64(options.HopLen) / sampleRate
+	return &noteTracker{
+		minConfidence:   options.MinConfidence,
+		hysteresisCents: options.NoteHysteresisCents,
+		minNoteFrames:   int(math.Max(1, math.Ceil(options.MinNoteDuration.Seconds()/hopSeconds))),
+		recent:          make([]float64, options.MedianFrames),
+		current:         SILENCE,
+		candidate:       SILENCE,
+	}
+}
+
+// Fills the tracked note, the raw note and the smoothed frequency of a frame.
+func (T *noteTracker) Track(frame *PitchFrame) {
+	frame.rawNote = classifyPitchFrame(*frame, T.minConfidence)
+	frame.smoothedFrequency = -1
+
+	// The pitch is smoothed even when it's no flute note, the tuner shows it.
+	proposal := frame.rawNote
+	pitched := !frame.silent && !frame.squeak && frame.frequency > 0 && frame.confidence >= T.minConfidence
+	if pitched {
+		T.recent[T.recentPos] = frame.frequency
+		T.recentPos = (T.recentPos + 1) % len(T.recent)
+		if T.recentLen < len(T.recent) {
+			T.recentLen++
+		}
+		frame.smoothedFrequency = median(T.recent[:T.recentLen])
+		match := musicNote.MNMatchNote(frame.smoothedFrequency)
+		proposal = match.note
+		if match.status == NOTE_UNKNOWN {
+			proposal = UNCERTAIN
+		}
+
+		if T.current >= 0 && proposal >= 0 && proposal != T.current {
+			// Stays with the current note unless the new one is clearly closer,
+			// or the current one is too far to still be played.
+			currentDistance := math.Abs(centsBetween(frame.smoothedFrequency, musicNote.frequency[T.current]))
+			proposalDistance := math.Abs(centsBetween(frame.smoothedFrequency, musicNote.frequency[proposal]))
+			if currentDistance <= NOTE_MAX_CENTS && currentDistance-proposalDistance < T.hysteresisCents {
+				proposal = T.current
+			}
+		}
+	} else {
+		// A gap in the note, the next one doesn't mix with the last.
+		T.recentLen = 0
+		T.recentPos = 0
+	}
+
+	switch {
+	case proposal == T.current:
+		T.candidateFrames = 0
+	case proposal == T.candidate:
+		T.candidateFrames++
+	default:
+		T.candidate = proposal
+		T.candidateFrames = 1
+	}
+	if T.candidateFrames >= T.minNoteFrames {
+		T.current = T.candidate
+		T.candidateFrames = 0
+	}
+	frame.note = T.current
+}
+
+// Frequency of the stable note, -1 when it isn't a flute note.
+func (T *noteTracker) CurrentFrequency() float64 {
+	if T.current < 0 {
+		return -1
+	}
+	return musicNote.frequency[T.current]
+}
@@ -0,0 +1,187 @@
+// Tests of the note tracker.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Tracks the frames in turn and returns the note after each one.
+func trackFrames(tracker *noteTracker, frames []PitchFrame) []int {
+	notes := make([]int, len(frames))
+	for i := range frames {
+		tracker.Track(&frames[i])
+		notes[i] = frames[i].note
+	}
+	return notes
+}
+
+// Frames of a note played some cents away from its frequency.
+func playedFrames(count int, note int, cents float64) []PitchFrame {
+	frames := make([]PitchFrame, count)
+	for i := range frames {
+		frames[i] = PitchFrame{frequency: musicNote.frequency[note] * math.Pow(2, cents/1200), confidence: 1}
+	}
+	return frames
+}
+
+// Frames of a note with a pitch that isn't confident.
+func unsureFrames(count int, note int) []PitchFrame {
+	frames := playedFrames(count, note, 0)
+	for i := range frames {
+		frames[i].confidence = defaultMinConfidence(DEFAULT_PITCH_DETECTOR) / 2
+	}
+	return frames
+}
+
+func silentFrames(count int) []PitchFrame {
+	frames := make([]PitchFrame, count)
+	for i := range frames {
+		frames[i] = PitchFrame{frequency: -1, silent: true}
+	}
+	return frames
+}
+
+func joinFrames(parts ...[]PitchFrame) []PitchFrame {
+	frames := []PitchFrame{}
+	for _, part := range parts {
+		frames = append(frames, part...)
+	}
+	return frames
+}
+
+func repeatNote(count int, note int) []int {
+	notes := make([]int, count)
+	for i := range notes {
+		notes[i] = note
+	}
+	return notes
+}
+
+func sameNotes(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func joinNotes(parts ...[]int) []int {
+	notes := []int{}
+	for _, part := range parts {
+		notes = append(notes, part...)
+	}
+	return notes
+}
+
+func TestNoteTracker(t *testing.T) {
+	musicNote.MNnew()
+	options := defaultAnalysisOptions()
+	// One frame is 512 samples, 11.6 ms, a note must last 3 frames to be 30 ms long.
+	options.MedianFrames = 1
+	tests := []struct {
+		name   string
+		frames []PitchFrame
+		want   []int
+	}{
+		{"a note after the minimum duration",
+			playedFrames(4, SOL, 0),
+			joinNotes(repeatNote(2, SILENCE), repeatNote(2, SOL))},
+		{"a shorter note is ignored",
+			joinFrames(playedFrames(4, DO, 0), playedFrames(2, RE, 0), playedFrames(3, DO, 0)),
+			joinNotes(repeatNote(2, SILENCE), repeatNote(7, DO))},
+		{"a shorter silence is ignored",
+			joinFrames(playedFrames(4, DO, 0), silentFrames(2), playedFrames(2, DO, 0)),
+			joinNotes(repeatNote(2, SILENCE), repeatNote(6, DO))},
+		{"the silence after the minimum duration",
+			joinFrames(playedFrames(3, DO, 0), silentFrames(4)),
+			joinNotes(repeatNote(2, SILENCE), repeatNote(3, DO), repeatNote(2, SILENCE))},
+		{"the next note after the minimum duration",
+			joinFrames(playedFrames(3, DO, 0), playedFrames(4, RE, 0)),
+			joinNotes(repeatNote(2, SILENCE), repeatNote(3, DO), repeatNote(2, RE))},
+		{"too far from every note is uncertain",
+			// 150 cents below DO, the lowest note.
+			playedFrames(4, DO, -150),
+			joinNotes(repeatNote(2, SILENCE), repeatNote(2, UNCERTAIN))},
+		{"not confident is uncertain",
+			joinFrames(playedFrames(3, LA, 0), unsureFrames(3, LA)),
+			joinNotes(repeatNote(2, SILENCE), repeatNote(3, LA), []int{UNCERTAIN})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := newNoteTracker(options, 44100)
+			if tracker.minNoteFrames != 3 {
+				t.Fatalf("%d frames of minimum duration, want 3", tracker.minNoteFrames)
+			}
+			notes := trackFrames(tracker, test.frames)
+			if !sameNotes(notes, test.want) {
+				t.Errorf("notes %v, want %v", notes, test.want)
+			}
+		})
+	}
+}
+
+func TestNoteTrackerHysteresis(t *testing.T) {
+	musicNote.MNnew()
+	options := defaultAnalysisOptions()
+	options.MedianFrames = 1
+	tests := []struct {
+		name    string
+		faCents float64 // FA above MI, a profile can have them closer than a semitone.
+		frames  []PitchFrame
+		want    []int
+	}{
+		{"keeps the note when the next one isn't clearly closer", 60,
+			// 35 cents above MI is 25 below FA, only 10 cents closer.
+			joinFrames(playedFrames(3, MI, 0), playedFrames(5, MI, 35)),
+			joinNotes(repeatNote(2, SILENCE), repeatNote(6, MI))},
+		{"the next note when it's clearly closer", 60,
+			// 50 cents above MI is 10 below FA, 40 cents closer.
+			joinFrames(playedFrames(3, MI, 0), playedFrames(5, MI, 50)),
+			joinNotes(repeatNote(2, SILENCE), repeatNote(3, MI), repeatNote(3, FA))},
+		{"doesn't keep a note too far to be played", 100,
+			// 35 cents above MI is 65 below FA, only 30 cents closer.
+			joinFrames(playedFrames(3, FA, 0), playedFrames(4, MI, 35)),
+			joinNotes(repeatNote(2, SILENCE), repeatNote(3, FA), repeatNote(2, MI))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			musicNote.MNnew()
+			defer musicNote.MNnew()
+			musicNote.frequency[FA] = musicNote.frequency[MI] * math.Pow(2, test.faCents/1200)
+			notes := trackFrames(newNoteTracker(options, 44100), test.frames)
+			if !sameNotes(notes, test.want) {
+				t.Errorf("notes %v, want %v", notes, test.want)
+			}
+		})
+	}
+}
+
+func TestNoteTrackerMedian(t *testing.T) {
+	musicNote.MNnew()
+	options := defaultAnalysisOptions()
+	options.MinNoteDuration = 0
+	tracker := newNoteTracker(options, 44100)
+	// A single wrong frame an octave up doesn't change the median of 5 frames.
+	frames := joinFrames(playedFrames(4, SOL, 0), playedFrames(1, DO_HIGH, 0), playedFrames(2, SOL, 0))
+	notes := trackFrames(tracker, frames)
+	for i, note := range notes {
+		if note != SOL {
+			t.Fatalf("notes %v, want only SOL", notes)
+		}
+		if math.Abs(centsBetween(frames[i].smoothedFrequency, musicNote.frequency[SOL])) > 1e-9 {
+			t.Errorf("frame %d smoothed to %g Hz, want %g Hz", i, frames[i].smoothedFrequency, musicNote.frequency[SOL])
+		}
+	}
+	// The tuner sees a clear pitch that is no flute note.
+	frames = playedFrames(1, DO, -150)
+	trackFrames(tracker, frames)
+	if frames[0].smoothedFrequency <= 0 {
+		t.Errorf("no smoothed frequency for a pitch that's no flute note")
+	}
+}
@@ -0,0 +1,141 @@
+// Octave correction and overblowing.
+//
+// The time domain detectors sometimes lock onto half the frequency, the
+// subharmonic, or skip a weak fundamental and answer the octave above. The
+// nearest note of a wrong octave is a completely different note on the flute.
+// The octave corrector checks the frequency found against the spectrum of the
+// window:
+//   - with little energy at the frequency and a lot at its double, it was a
+//     subharmonic and the double is the pitch;
+//   - with real energy at half the frequency, the fundamental was skipped and
+//     the half is the pitch;
+//   - when the energy is about the same, the octave closest to the note being
+//     played wins.
+//
+// Only octaves inside the range of the flute are considered. Blowing too hard
+// makes the recorder squeak, a strong peak above the highest note, that is
+// reported as a squeak and not as some note.
+
+package main
+
+import (
+	"math"
+)
+
+const (
+	OCTAVE_WEAK_RATIO       float64 = 0.1 // A fundamental with less than this fraction of the energy of its double is a subharmonic.
+	OCTAVE_STRONG_RATIO     float64 = 0.5 // Half the frequency with more than this fraction of the energy is the fundamental.
+	SQUEAK_MIN_ENERGY_SHARE float64 = 0.5 // Fraction of the energy in a peak above the range for a squeak.
+	SQUEAK_MAX_FREQUENCY    float64 = 5000.0
+)
+
+type octaveCorrector struct {
+	sampleRate   float64
+	minFrequency float64
+	maxFrequency float64
+	plan         *fftPlan
+	data         []complex128
+	hann         []float64
+	power        []float64 // Power spectrum of the last window.
+}
+
+func newOctaveCorrector(windowLen int, sampleRate float64, minFrequency float64, maxFrequency float64) *octaveCorrector {
+	plan := newFFTPlan(windowLen)
+	hann := make([]float64, windowLen)
+	for i := range hann {
+		hann[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(windowLen))
+	}
+	return &octaveCorrector{
+		sampleRate:   sampleRate,
+		minFrequency: minFrequency,
+		maxFrequency: maxFrequency,
+		plan:         plan,
+		data:         make([]complex128, plan.size),
+		hann:         hann,
+		power:        make([]float64, plan.size/2),
+	}
+}
+
+// Energy of the spectrum around a frequency, the main lobe of the Hann window.
+func (O *octaveCorrector) energyAt(frequency float64) float64 {
+	bin := int(math.Round(frequency * float64(O.plan.size) / O.sampleRate))
+	energy := 0.0
+	for k := bin - 2; k <= bin+2; k++ {
+		if k >= 0 && k < len(O.power) {
+			energy += O.power[k]
+		}
+	}
+	return energy
+}
+
+func (O *octaveCorrector) inRange(frequency float64) bool {
+	return frequency >= O.minFrequency && frequency <= O.maxFrequency
+}
+
+// Returns the frequency in the right octave, or squeak true when the window is
+// an overblown squeak. frequency is -1 when the detector found no pitch and
+// recentFrequency is the frequency of the note being played, -1 if none.
+func (O *octaveCorrector) Correct(window []float32, frequency float64, recentFrequency float64) (corrected float64, squeak bool) {
+	for i := range O.data {
+		O.data[i] = 0
+		if i < len(window) {
+			O.data[i] = complex(float64(window[i])*O.hann[i], 0)
+		}
+	}
+	O.plan.Transform(O.data, false)
+	total := 0.0
+	peakBin := 0
+	binHz := O.sampleRate / float64(O.plan.size)
+	for k := range O.power {
+		value := O.data[k]
+		O.power[k] = real(value)*real(value) + imag(value)*imag(value)
+		total += O.power[k]
+		if float64(k)*binHz <= SQUEAK_MAX_FREQUENCY && O.power[k] > O.power[peakBin] {
+			peakBin = k
+		}
+	}
+	if total == 0 {
+		return frequency, false
+	}
+
+	// A squeak is a strong peak above the notes the flute plays, with little
+	// at the frequency the detector found below it.
+	peakFrequency := float64(peakBin) * binHz
+	if peakFrequency > O.maxFrequency && O.energyAt(peakFrequency) >= SQUEAK_MIN_ENERGY_SHARE*total {
+		if frequency < 0 || O.energyAt(frequency) < OCTAVE_WEAK_RATIO*O.energyAt(peakFrequency) {
+			return -1, true
+		}
+	}
+	if frequency < 0 {
+		return frequency, false
+	}
+
+	energy := O.energyAt(frequency)
+	if double := 2 * frequency; O.inRange(double) {
+		doubleEnergy := O.energyAt(double)
+		if energy < OCTAVE_WEAK_RATIO*doubleEnergy {
+			return double, false
+		}
+		if energy < OCTAVE_STRONG_RATIO*doubleEnergy && closerInCents(recentFrequency, double, frequency) {
+			return double, false
+		}
+	}
+	if half := frequency / 2; O.inRange(half) {
+		halfEnergy := O.energyAt(half)
+		if halfEnergy >= OCTAVE_STRONG_RATIO*energy {
+			return half, false
+		}
+		if halfEnergy >= OCTAVE_WEAK_RATIO*energy && closerInCents(recentFrequency, half, frequency) {
+			return half, false
+		}
+	}
+	return frequency, false
+}
+
+// True if reference is closer to a than to b, in cents. False without a reference.
+func closerInCents(reference float64, a float64, b float64) bool {
+	if reference <= 0 {
+		return false
+	}
+	return math.Abs(centsBetween(a, reference)) < math.Abs(centsBetween(b, reference))
+}
@@ -0,0 +1,88 @@
+// Tests of the octave correction and the squeak detection.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// A window with sine partials, each a frequency and its amplitude.
+func partialsWindow(windowLen int, sampleRate float64, partials ...[2]float64) []float32 {
+	window := make([]float32, windowLen)
+	for i := range window {
+		t := float64(i) / sampleRate
+		value := 0.0
+		for _, partial := range partials {
+			value += partial[1] * math.Sin(2*math.Pi*partial[0]*t)
+		}
+		window[i] = float32(value)
+	}
+	return window
+}
+
+func TestOctaveCorrector(t *testing.T) {
+	musicNote.MNnew()
+	sampleRate := float64(YIN_SAMPLING_RATE)
+	do := musicNote.frequency[DO]
+	doHigh := musicNote.frequency[DO_HIGH]
+	recorderDo := benchYinWindow(DEFAULT_WINDOW_LEN, sampleRate, do)
+	recorderDoHigh := benchYinWindow(DEFAULT_WINDOW_LEN, sampleRate, doHigh)
+	// The fundamental with a quarter of the energy of the octave, any of them may be the note.
+	weakDo := partialsWindow(DEFAULT_WINDOW_LEN, sampleRate, [2]float64{do, 0.2}, [2]float64{doHigh, 0.4})
+	squeak := partialsWindow(DEFAULT_WINDOW_LEN, sampleRate, [2]float64{2100, 0.5})
+
+	tests := []struct {
+		name       string
+		window     []float32
+		frequency  float64 // Found by the detector.
+		recent     float64 // The note being played.
+		want       float64
+		wantSqueak bool
+	}{
+		{"right pitch", recorderDo, do, -1, do, false},
+		{"right pitch an octave up", recorderDoHigh, doHigh, -1, doHigh, false},
+		{"subharmonic", recorderDo, do / 2, -1, do, false},
+		{"skipped fundamental", recorderDo, doHigh, -1, do, false},
+		{"weak fundamental alone", weakDo, do, -1, do, false},
+		{"weak fundamental while playing the octave", weakDo, do, doHigh, doHigh, false},
+		{"weak fundamental while playing it", weakDo, do, do, do, false},
+		{"octave while playing the fundamental", weakDo, doHigh, do, do, false},
+		{"octave while playing it", weakDo, doHigh, doHigh, doHigh, false},
+		{"no pitch", recorderDo, -1, -1, -1, false},
+		{"silence", make([]float32, DEFAULT_WINDOW_LEN), -1, -1, -1, false},
+		{"squeak without a pitch", squeak, -1, -1, -1, true},
+		{"squeak with a wrong pitch", squeak, 700, -1, -1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			minFrequency, maxFrequency := musicNote.MNFrequencyRange()
+			corrector := newOctaveCorrector(DEFAULT_WINDOW_LEN, sampleRate, minFrequency, maxFrequency)
+			corrected, squeak := corrector.Correct(test.window, test.frequency, test.recent)
+			if corrected != test.want || squeak != test.wantSqueak {
+				t.Errorf("Correct(%g, %g) = %g, %v, want %g, %v", test.frequency, test.recent, corrected, squeak, test.want, test.wantSqueak)
+			}
+		})
+	}
+}
+
+func TestCloserInCents(t *testing.T) {
+	tests := []struct {
+		reference float64
+		a         float64
+		b         float64
+		want      bool
+	}{
+		{440, 441, 880, true},
+		{440, 880, 441, false},
+		{-1, 441, 880, false},
+		{0, 441, 880, false},
+		// In cents 600 Hz is closer to 440 than 300 Hz, in Hz it isn't.
+		{440, 600, 300, true},
+	}
+	for _, test := range tests {
+		if got := closerInCents(test.reference, test.a, test.b); got != test.want {
+			t.Errorf("closerInCents(%g, %g, %g) = %v, want %v", test.reference, test.a, test.b, got, test.want)
+		}
+	}
+}
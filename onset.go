@@ -0,0 +1,145 @@
+// Onset detection.
+//
+// The pitch alone can't tell two tongued notes from one long note, the player
+// must start each note with the tongue, a short "tu" that cuts the air. The
+// onset detector finds the start of every note:
+//   - when the noise gate opens, a note after a silence;
+//   - when the spectral flux, how much the spectrum grows from one hop to the
+//     next, jumps above its recent average, the chiff of the tongue or a new
+//     note played legato;
+//   - when the level rises fast, a note tongued after a short dip.
+//
+// It looks only at the last ONSET_FRAME_SECONDS of each analysis window, the
+// long window of the pitch detection would blur the attack.
+
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+const (
+	ONSET_FRAME_SECONDS   float64 = 0.023 // Length of the audio looked at, before the end of each window.
+	ONSET_HISTORY_SECONDS float64 = 0.25  // Time of the flux average the jumps are compared to.
+	ONSET_FLUX_RATIO      float64 = 2.0   // How many times the average the flux must be.
+	ONSET_MIN_FLUX        float64 = 0.05  // Minimum flux for an onset, so the noise of a steady note isn't one.
+	ONSET_ENERGY_RISE_DB  float64 = 9.0   // Rise of the level that is an onset.
+	ONSET_ENERGY_SECONDS  float64 = 0.05  // Time the rise of the level is measured in.
+	ONSET_MIN_INTERVAL    float64 = 0.06  // Shortest time in seconds between two onsets.
+)
+
+type onsetDetector struct {
+	sampleRate   float64
+	plan         *fftPlan
+	data         []complex128
+	hann         []float64
+	spectrum     []float64 // Compressed magnitudes of the last frame.
+	previous     []float64 // The same for the frame before.
+	fluxHistory  []float64 // Recent flux values, circular.
+	fluxPos      int
+	lastFlux     float64
+	levelHistory []float64 // Recent levels in dB, circular.
+	levelPos     int
+	lastOnset    int64 // Sample time of the last onset, -1 if none yet.
+	minInterval  int64
+	wasSilent    bool
+}
+
+func newOnsetDetector(sampleRate float64, hopLen int) *onsetDetector {
+	plan := newFFTPlan(int(ONSET_FRAME_SECONDS * sampleRate))
+	hann := make([]float64, plan.size)
+	for i := range hann {
+		hann[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(plan.size))
+	}
+	hopSeconds := float64(hopLen) / sampleRate
+	levelHistory := make([]float64, int(math.Max(1, math.Round(ONSET_ENERGY_SECONDS/hopSeconds))))
+	for i := range levelHistory {
+		levelHistory[i] = LEVEL_FLOOR_DB
+	}
+	return &onsetDetector{
+		sampleRate:   sampleRate,
+		plan:         plan,
+		data:         make([]complex128, plan.size),
+		hann:         hann,
+		spectrum:     make([]float64, plan.size/2),
+		previous:     make([]float64, plan.size/2),
+		fluxHistory:  make([]float64, int(math.Max(1, math.Round(ONSET_HISTORY_SECONDS/hopSeconds)))),
+		levelHistory: levelHistory,
+		lastOnset:    -1,
+		minInterval:  int64(ONSET_MIN_INTERVAL * sampleRate),
+		wasSilent:    true,
+	}
+}
+
+// Looks at the end of the window that ended at sampleTime, returns true if a
+// note started in it.
+func (O *onsetDetector) Process(window []float32, sampleTime int64, silent bool) bool {
+	frameLen := O.plan.size
+	if frameLen > len(window) {
+		frameLen = len(window)
+	}
+	frame := window[len(window)-frameLen:]
+
+	sumSquares := 0.0
+	for i := range O.data {
+		O.data[i] = 0
+		if i < frameLen {
+			value := float64(frame[i])
+			sumSquares += value * value
+			O.data[i] = complex(value*O.hann[i], 0)
+		}
+	}
+	levelDB := amplitudeToDB(math.Sqrt(sumSquares / float64(frameLen)))
+	O.plan.Transform(O.data, false)
+
+	// The flux is the growth of the log compressed spectrum, only the bins
+	// that got louder count.
+	O.previous, O.spectrum = O.spectrum, O.previous
+	flux := 0.0
+	for k := range O.spectrum {
+		O.spectrum[k] = math.Log1p(100 * cmplx.Abs(O.data[k]))
+		if growth := O.spectrum[k] - O.previous[k]; growth > 0 {
+			flux += growth
+		}
+	}
+	flux /= float64(len(O.spectrum))
+
+	average := 0.0
+	for _, value := range O.fluxHistory {
+		average += value
+	}
+	average /= float64(len(O.fluxHistory))
+	fluxThreshold := math.Max(ONSET_MIN_FLUX, ONSET_FLUX_RATIO*average)
+	lowestLevel := 0.0
+	for _, value := range O.levelHistory {
+		lowestLevel = math.Min(lowestLevel, value)
+	}
+
+	// Only the frame where the flux goes above the threshold, not the ones after it.
+	fluxJump := flux > fluxThreshold && O.lastFlux <= fluxThreshold
+	levelRise := levelDB-lowestLevel >= ONSET_ENERGY_RISE_DB
+	gateOpened := O.wasSilent && !silent
+
+	O.fluxHistory[O.fluxPos] = flux
+	O.fluxPos = (O.fluxPos + 1) % len(O.fluxHistory)
+	O.levelHistory[O.levelPos] = levelDB
+	O.levelPos = (O.levelPos + 1) % len(O.levelHistory)
+	O.lastFlux = flux
+	O.wasSilent = silent
+
+	if silent || !(gateOpened || fluxJump || levelRise) {
+		return false
+	}
+	if O.lastOnset >= 0 && sampleTime-O.lastOnset < O.minInterval {
+		return false
+	}
+	O.lastOnset = sampleTime
+	return true
+}
+
+// Estimated sample time where the note started, for an onset found in the
+// window that ended at sampleTime. The attack was somewhere in the last frame.
+func (O *onsetDetector) OnsetTime(sampleTime int64) int64 {
+	return sampleTime - int64(O.plan.size/2)
+}
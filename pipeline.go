@@ -0,0 +1,251 @@
+// Audio pipeline.
+//
+// The audio callback must return quickly, so it only copies the samples into
+// a lock free ring buffer. An analysis goroutine takes the samples from the
+// ring buffer and detects the pitch, then sends one AnalysisFrame per game
+// step through a channel to the render goroutine, the only one that touches
+// the screenBuffer and the score.
+//
+//	audio callback --ring buffer--> analysis goroutine --channel--> render goroutine
+//
+// With a live source nothing can wait for a slow stage, samples that don't fit
+// in the ring buffer and frames that don't fit in the channel are dropped and
+// counted, and the gaps they leave in the audio are handed to the session
+// recording. The other sources are paced by the pipeline instead.
+
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Seconds of audio the ring buffer can hold.
+const RING_BUFFER_SECONDS float64 = 2.0
+
+// Number of analysis frames waiting for the render goroutine.
+const FRAME_QUEUE_LEN int = 8
+
+// Number of gaps in the audio waiting for the analysis goroutine.
+const GAP_QUEUE_LEN int = 64
+
+// How long the goroutines sleep while waiting for samples or for free space.
+const PIPELINE_POLL_INTERVAL time.Duration = 5 * time.Millisecond
+
+// Counters of the audio that was lost because the pipeline fell behind.
+var overrunSamples uint64 = 0 // Samples that didn't fit in the ring buffer.
+var droppedFrames uint64 = 0  // Analysis frames that didn't fit in the render queue.
+
+// Result of the analysis of one game step.
+type AnalysisFrame struct {
+	sampleTime int64   // Index in the audio of the sample after the end of the step.
+	note       int     // Flute note played, SILENCE or UNCERTAIN.
+	frequency  float64 // Detected frequency in Hz, -1 if none was found.
+	confidence float64 // Certainty of the frequency, from 0 to 1.
+	clarity    float64 // How periodic the sound is, from 0 to 1.
+	rmsDB      float64 // Loudest RMS level of the step in dBFS.
+	peakDB     float64 // Loudest peak level of the step in dBFS.
+	silent     bool    // The noise gate found no sound in the step.
+	onsetTimes []int64 // Sample times of the notes started in the step.
+	tongued    bool    // A note started in the step, or TONGUE_EARLY_SECONDS before it.
+}
+
+// Audio of a live source lost because the ring buffer was full.
+type audioGap struct {
+	position uint64 // Samples the analysis got before the gap.
+	length   int    // Samples lost.
+}
+
+//##################
+// Single producer, single consumer ring buffer of samples.
+
+type sampleRingBuffer struct {
+	// The positions come first, the atomic operations on them need 64 bit
+	// alignment and only the start of the struct has it in a 32 bit build.
+	writePos uint64 // Total samples written, only changed by the producer.
+	readPos  uint64 // Total samples read, only changed by the consumer.
+	mask     uint64 // len(buffer) - 1, the length is a power of two.
+	buffer   []float32
+}
+
+func newSampleRingBuffer(minLen int) *sampleRingBuffer {
+	length := 1
+	for length < minLen {
+		length *= 2
+	}
+	return &sampleRingBuffer{buffer: make([]float32, length), mask: uint64(length - 1)}
+}
+
+func (R *sampleRingBuffer) Free() int {
+	return len(R.buffer) - int(atomic.LoadUint64(&R.writePos)-atomic.LoadUint64(&R.readPos))
+}
+
+// Writes the frames mixed down to mono, returns the number of frames that fit.
+func (R *sampleRingBuffer) PushMono(in []float32, channels int) int {
+	numFrames := len(in) / channels
+	if free := R.Free(); numFrames > free {
+		numFrames = free
+	}
+	writePos := atomic.LoadUint64(&R.writePos)
+	for i := 0; i < numFrames; i++ {
+		var sum float32 = 0
+		for c := 0; c < channels; c++ {
+			sum += in[i*channels+c]
+		}
+		R.buffer[(writePos+uint64(i))&R.mask] = sum / float32(channels)
+	}
+	// Publishes the samples only after they are written.
+	atomic.StoreUint64(&R.writePos, writePos+uint64(numFrames))
+	return numFrames
+}
+
+// Reads the available samples into out, returns how many were read.
+func (R *sampleRingBuffer) Pop(out []float32) int {
+	readPos := atomic.LoadUint64(&R.readPos)
+	available := int(atomic.LoadUint64(&R.writePos) - readPos)
+	if available > len(out) {
+		available = len(out)
+	}
+	for i := 0; i < available; i++ {
+		out[i] = R.buffer[(readPos+uint64(i))&R.mask]
+	}
+	atomic.StoreUint64(&R.readPos, readPos+uint64(available))
+	return available
+}
+
+//##################
+// The pipeline.
+
+type audioPipeline struct {
+	ring       *sampleRingBuffer
+	channels   int
+	live       bool
+	frames     chan AnalysisFrame
+	gaps       chan audioGap
+	inputDone  chan struct{} // Closed when the source has no more audio.
+	renderDone chan struct{} // Closed when the last frame was rendered.
+}
+
+// Creates the pipeline for a source and sets the analysis to its sample rate.
+func newAudioPipeline(source AudioSource, options AnalysisOptions) *audioPipeline {
+	setupAnalysis(source.SampleRate(), options)
+	return &audioPipeline{
+		ring:       newSampleRingBuffer(int(RING_BUFFER_SECONDS * source.SampleRate())),
+		channels:   source.Channels(),
+		live:       source.Live(),
+		frames:     make(chan AnalysisFrame, FRAME_QUEUE_LEN),
+		gaps:       make(chan audioGap, GAP_QUEUE_LEN),
+		inputDone:  make(chan struct{}),
+		renderDone: make(chan struct{}),
+	}
+}
+
+// Starts the analysis and render goroutines.
+func (P *audioPipeline) Start() {
+	go P.analyse()
+	go P.render()
+}
+
+// The handler to give to the audio source, it only fills the ring buffer.
+func (P *audioPipeline) FrameHandler() AudioFrameHandler {
+	pending := audioGap{} // Gap not yet handed to the analysis goroutine.
+	return func(in []float32) {
+		for {
+			written := P.ring.PushMono(in, P.channels)
+			in = in[written*P.channels:]
+			if len(in) == 0 {
+				return
+			}
+			if P.live {
+				lost := len(in) / P.channels
+				atomic.AddUint64(&overrunSamples, uint64(lost))
+				P.sendGap(&pending, atomic.LoadUint64(&P.ring.writePos), lost)
+				return
+			}
+			// A file can wait for the analysis to catch up.
+			time.Sleep(PIPELINE_POLL_INTERVAL)
+		}
+	}
+}
+
+// Hands a gap to the analysis goroutine without waiting. While the queue is
+// full the gaps are added to the pending one, the length of the audio lost
+// stays right even if its position is the first gap's.
+func (P *audioPipeline) sendGap(pending *audioGap, position uint64, length int) {
+	if pending.length == 0 {
+		pending.position = position
+	}
+	pending.length += length
+	select {
+	case P.gaps <- *pending:
+		*pending = audioGap{}
+	default:
+	}
+}
+
+// Tells the pipeline that the source has no more audio and waits until
+// everything in it was analysed and rendered.
+func (P *audioPipeline) Finish() {
+	close(P.inputDone)
+	<-P.renderDone
+}
+
+func (P *audioPipeline) analyse() {
+	defer close(P.frames)
+
+	block := make([]float32, SOURCE_BLOCK_LEN)
+	for {
+		P.logGaps()
+		n := P.ring.Pop(block)
+		if n == 0 {
+			select {
+			case <-P.inputDone:
+				// The source is finished, but its last samples may have arrived meanwhile.
+				if P.ring.Free() == len(P.ring.buffer) {
+					return
+				}
+			default:
+				time.Sleep(PIPELINE_POLL_INTERVAL)
+			}
+			continue
+		}
+		if session != nil {
+			session.WriteSamples(block[:n])
+		}
+		processSamples(block[:n], P.sendFrame)
+	}
+}
+
+// Adds the gaps of the audio to the session recording, the WAV file doesn't have that audio.
+func (P *audioPipeline) logGaps() {
+	for {
+		select {
+		case gap := <-P.gaps:
+			if session != nil {
+				session.AddGap(gap.position, gap.length)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (P *audioPipeline) sendFrame(frame AnalysisFrame) {
+	if !P.live {
+		P.frames <- frame
+		return
+	}
+	select {
+	case P.frames <- frame:
+	default:
+		atomic.AddUint64(&droppedFrames, 1)
+	}
+}
+
+func (P *audioPipeline) render() {
+	defer close(P.renderDone)
+
+	for frame := range P.frames {
+		renderGameStep(frame)
+	}
+}
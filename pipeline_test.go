@@ -0,0 +1,133 @@
+// Tests of the ring buffer of the audio pipeline.
+
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestSampleRingBufferLength(t *testing.T) {
+	tests := []struct {
+		minLen int
+		want   int
+	}{
+		{1, 1},
+		{2, 2},
+		{3, 4},
+		{1000, 1024},
+		{1024, 1024},
+		{88200, 131072},
+	}
+	for _, test := range tests {
+		if ring := newSampleRingBuffer(test.minLen); len(ring.buffer) != test.want || ring.Free() != test.want {
+			t.Errorf("newSampleRingBuffer(%d) holds %d, free %d, want %d", test.minLen, len(ring.buffer), ring.Free(), test.want)
+		}
+	}
+}
+
+func TestSampleRingBufferWraparound(t *testing.T) {
+	tests := []struct {
+		name     string
+		push     int // Frames pushed each time.
+		pop      int // Room for the frames popped each time.
+		channels int
+	}{
+		{"push and pop the same", 5, 5, 1},
+		{"pop less than pushed", 7, 3, 1},
+		{"pop more than pushed", 3, 11, 1},
+		{"the whole buffer", 16, 16, 1},
+		{"stereo mixed down", 6, 4, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ring := newSampleRingBuffer(16)
+			pushed, popped := 0, 0
+			out := make([]float32, test.pop)
+			// Goes around the buffer many times, each sample is its frame number.
+			for round := 0; round < 100; round++ {
+				in := make([]float32, test.push*test.channels)
+				for i := 0; i < test.push; i++ {
+					for c := 0; c < test.channels; c++ {
+						// The channels average to the frame number.
+						in[i*test.channels+c] = float32(pushed+i) + float32(2*c-test.channels+1)
+					}
+				}
+				written := ring.PushMono(in, test.channels)
+				if want := minInt(test.push, 16-(pushed-popped)); written != want {
+					t.Fatalf("round %d: wrote %d frames, want %d", round, written, want)
+				}
+				pushed += written
+
+				n := ring.Pop(out)
+				if want := minInt(test.pop, pushed-popped); n != want {
+					t.Fatalf("round %d: read %d samples, want %d", round, n, want)
+				}
+				for i := 0; i < n; i++ {
+					if out[i] != float32(popped+i) {
+						t.Fatalf("round %d: sample %d is %g, want %d", round, i, out[i], popped+i)
+					}
+				}
+				popped += n
+				if free := ring.Free(); free != 16-(pushed-popped) {
+					t.Fatalf("round %d: %d free, want %d", round, free, 16-(pushed-popped))
+				}
+			}
+		})
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestFrameHandlerCountsOverrun(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels int
+		blocks   []int // Frames of each block handed to the handler.
+		popAfter []int // Samples read after each block.
+		overrun  uint64
+		gaps     []audioGap
+	}{
+		{"fits", 1, []int{8, 8}, []int{0, 0}, 0, nil},
+		{"one block too many", 1, []int{8, 8, 8}, []int{0, 0, 0}, 8, []audioGap{{16, 8}}},
+		{"partly fits", 2, []int{10, 10}, []int{0, 0}, 4, []audioGap{{16, 4}}},
+		{"two gaps", 1, []int{20, 10}, []int{5, 0}, 9, []audioGap{{16, 4}, {21, 5}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreUint64(&overrunSamples, 0)
+			pipeline := &audioPipeline{
+				ring:     newSampleRingBuffer(16),
+				channels: test.channels,
+				live:     true,
+				gaps:     make(chan audioGap, GAP_QUEUE_LEN),
+			}
+			handler := pipeline.FrameHandler()
+			for i, frames := range test.blocks {
+				handler(make([]float32, frames*test.channels))
+				pipeline.ring.Pop(make([]float32, test.popAfter[i]))
+			}
+			if overrun := atomic.LoadUint64(&overrunSamples); overrun != test.overrun {
+				t.Errorf("%d samples overrun, want %d", overrun, test.overrun)
+			}
+			gaps := []audioGap{}
+			for len(pipeline.gaps) > 0 {
+				gaps = append(gaps, <-pipeline.gaps)
+			}
+			if len(gaps) != len(test.gaps) {
+				t.Fatalf("gaps %v, want %v", gaps, test.gaps)
+			}
+			for i := range gaps {
+				if gaps[i] != test.gaps[i] {
+					t.Errorf("gaps %v, want %v", gaps, test.gaps)
+				}
+			}
+		})
+	}
+	atomic.StoreUint64(&overrunSamples, 0)
+}
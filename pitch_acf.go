@@ -0,0 +1,81 @@
+// Plain autocorrelation.
+//
+// The window multiplied by itself shifted by tau and summed is highest when
+// tau is the period. Normalised by the energy of the window it goes from -1
+// to 1. Each shift sums fewer products than the one before, so the longer
+// periods, the octaves below, come out a little lower and the true period
+// wins. It's the simplest detector and the least robust to noise.
+
+package main
+
+import (
+	"math"
+)
+
+// Below this normalised autocorrelation there is no pitch.
+const ACF_MIN_CONFIDENCE float64 = 0.3
+
+type acfDetector struct {
+	sampleRate     float64
+	tauMin         int
+	tauEnd         int
+	autocorrelator *autocorrelator
+	correlation    []float64
+	energy         []float64 // Running sum of the squares of the window.
+}
+
+func newACFDetector(windowLen int, sampleRate float64, minFrequency float64, maxFrequency float64) *acfDetector {
+	tauMin, tauEnd := tauRange(sampleRate, minFrequency, maxFrequency, windowLen/2)
+	return &acfDetector{
+		sampleRate:     sampleRate,
+		tauMin:         tauMin,
+		tauEnd:         tauEnd,
+		autocorrelator: newAutocorrelator(windowLen),
+		correlation:    make([]float64, tauEnd+1),
+		energy:         make([]float64, windowLen+1),
+	}
+}
+
+func (D *acfDetector) Name() string {
+	return "acf"
+}
+
+func (D *acfDetector) DetectPitch(window []float32) PitchEstimate {
+	estimate := PitchEstimate{frequency: -1, confidence: 0, clarity: 0}
+	D.autocorrelator.Compute(window, D.correlation)
+	if D.correlation[0] <= 0 {
+		return estimate
+	}
+
+	// The highest local maximum in the range.
+	best := -1
+	for tau := D.tauMin; tau < D.tauEnd; tau++ {
+		if D.correlation[tau] > D.correlation[tau-1] && D.correlation[tau] >= D.correlation[tau+1] {
+			if best == -1 || D.correlation[tau] > D.correlation[best] {
+				best = tau
+			}
+		}
+	}
+	if best == -1 {
+		return estimate
+	}
+
+	// The clarity is the correlation of the two overlapping parts, without
+	// the loss of the shorter sum.
+	for i, sample := range window {
+		D.energy[i+1] = D.energy[i] + float64(sample)*float64(sample)
+	}
+	n := len(window)
+	overlapEnergy := math.Sqrt(D.energy[n-best] * (D.energy[n] - D.energy[best]))
+	if overlapEnergy > 0 {
+		estimate.clarity = math.Max(0, D.correlation[best]/overlapEnergy)
+	}
+
+	confidence := D.correlation[best] / D.correlation[0]
+	if confidence >= ACF_MIN_CONFIDENCE {
+		offset := parabolicPeakOffset(D.correlation[best-1], D.correlation[best], D.correlation[best+1])
+		estimate.frequency = D.sampleRate / (float64(best) + offset)
+		estimate.confidence = confidence
+	}
+	return estimate
+}
@@ -0,0 +1,340 @@
+// Accuracy benchmark of the pitch detection.
+//
+// The bench-pitch command runs the pitch detectors over labelled audio and
+// tells how close they are to what was really played:
+//
+//	galileu_flute.exe bench-pitch ./corpus
+//	galileu_flute.exe bench-pitch
+//
+// With a directory it reads every WAV file that has a label file next to it,
+// with the same name and a .txt extension. Each line of a label file is a
+// time range in seconds and what was played in it, separated by spaces or
+// tabs, the format of the label tracks of Audacity:
+//
+//	0.50	1.20	SOL
+//	1.20	1.45	silence
+//	1.45	2.00	784.5
+//
+// The label is a flute note, EMPTY, DO, RE, MI, FA, SOL, LA, SI or DO_HIGH,
+// a frequency in Hz or silence. Audio outside the labels isn't judged.
+// Without a directory it uses recorder tones of the synthesizer, every note
+// with and without vibrato and detune.
+//
+// Each frame is judged at the centre of its window, with the settings of the
+// command line, the noise gate and the note tracker included. --pitch runs
+// only one detector, else all are compared. A frame heard a pitch when the
+// noise gate was open and the detector found a frequency with at least the
+// --min-confidence, near a flute note or not:
+//
+//	voicing  frames that heard a pitch when a note was played, and none in
+//	         the silences;
+//	<50c     played frames with the frequency within 50 cents;
+//	cents    median error in cents of the played frames that heard a pitch;
+//	octave   played frames that heard a pitch an octave, or more, off;
+//	note     played frames with the right tracked note;
+//	latency  median time from the start of a note to the game showing it;
+//	missed   notes the game never showed.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	BENCH_PITCH_TOLERANCE_CENTS float64 = 50  // Error of a frame with the right pitch.
+	BENCH_SYNTH_NOTE_SECONDS    float64 = 0.5 // Duration of each note of the synthesized corpus.
+	BENCH_SYNTH_REST_SECONDS    float64 = 0.2 // Silence between them.
+)
+
+// Vibrato and detune in cents of the recordings of the synthesized corpus.
+var benchSynthConditions = [][2]float64{{0, 0}, {20, 0}, {0, -25}, {0, 25}, {30, -15}}
+
+// What was played in a time range of a recording.
+type pitchLabel struct {
+	start     float64 // Seconds.
+	end       float64
+	frequency float64 // Hz, 0 for a silence.
+}
+
+// A mono recording with its labels.
+type benchClip struct {
+	name       string
+	samples    []float32
+	sampleRate float64
+	labels     []pitchLabel
+}
+
+type pitchBenchResult struct {
+	frames       int // Frames inside a label.
+	voicingRight int
+	voicedFrames int // Frames of a played note.
+	heardFrames  int // Frames of a played note that heard a pitch.
+	inTolerance  int
+	centsErrors  []float64 // Absolute error of the heard frames.
+	octaveErrors int
+	notesRight   int
+	latencies    []float64 // Seconds, of each note shown.
+	notes        int
+	notesMissed  int
+}
+
+// Reads the label file of a recording.
+func readPitchLabels(labelFilePathAndName string) ([]pitchLabel, error) {
+	raw, err := ioutil.ReadFile(labelFilePathAndName)
+	if err != nil {
+		return nil, err
+	}
+	labels := []pitchLabel{}
+	for i, line := range strings.Split(string(raw), "\n") {
+		fields := strings.Fields(line)
+		// Audacity writes the frequency range of a label in a line starting with \.
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "\\") {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s line %d: expected start, end and label", labelFilePathAndName, i+1)
+		}
+		start, errStart := strconv.ParseFloat(fields[0], 64)
+		end, errEnd := strconv.ParseFloat(fields[1], 64)
+		if errStart != nil || errEnd != nil || end < start {
+			return nil, fmt.Errorf("%s line %d: bad time range %q to %q", labelFilePathAndName, i+1, fields[0], fields[1])
+		}
+		frequency, err := parsePitchLabel(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", labelFilePathAndName, i+1, err.Error())
+		}
+		labels = append(labels, pitchLabel{start: start, end: end, frequency: frequency})
+	}
+	return labels, nil
+}
+
+// Frequency of a label, a note name, a frequency in Hz or silence.
+func parsePitchLabel(label string) (float64, error) {
+	if strings.EqualFold(label, "silence") {
+		return 0, nil
+	}
+	for i, name := range fluteNoteNames {
+		if strings.EqualFold(label, name) {
+			return musicNote.frequency[i], nil
+		}
+	}
+	frequency, err := strconv.ParseFloat(label, 64)
+	if err != nil || frequency <= 0 {
+		return 0, fmt.Errorf("the label %q isn't a note, a frequency or silence", label)
+	}
+	return frequency, nil
+}
+
+// Reads the labelled WAV files of a directory, mixed down to mono.
+func readBenchCorpus(directory string) ([]benchClip, error) {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	clips := []benchClip{}
+	for _, file := range files {
+		if file.IsDir() || !strings.EqualFold(filepath.Ext(file.Name()), ".wav") {
+			continue
+		}
+		wavPath := filepath.Join(directory, file.Name())
+		labelPath := strings.TrimSuffix(wavPath, filepath.Ext(wavPath)) + ".txt"
+		labels, err := readPitchLabels(labelPath)
+		if err != nil {
+			fmt.Printf(" Skipping %s, no labels: %s\n", file.Name(), err.Error())
+			continue
+		}
+		samples, info, err := readWavFile(wavPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", wavPath, err.Error())
+		}
+		mono := make([]float32, len(samples)/info.Channels)
+		for i := range mono {
+			sum := float32(0)
+			for c := 0; c < info.Channels; c++ {
+				sum += samples[i*info.Channels+c]
+			}
+			mono[i] = sum / float32(info.Channels)
+		}
+		clips = append(clips, benchClip{name: file.Name(), samples: mono, sampleRate: float64(info.SampleRate), labels: labels})
+	}
+	if len(clips) == 0 {
+		return nil, fmt.Errorf("there are no WAV files with labels in %s", directory)
+	}
+	return clips, nil
+}
+
+// Recordings of the synthesizer, every flute note in turn with a silence
+// between them, once for each vibrato and detune.
+func synthBenchCorpus(sampleRate float64) []benchClip {
+	clips := []benchClip{}
+	noteLen := int(BENCH_SYNTH_NOTE_SECONDS * sampleRate)
+	restLen := int(BENCH_SYNTH_REST_SECONDS * sampleRate)
+	for _, condition := range benchSynthConditions {
+		vibrato, detune := condition[0], condition[1]
+		notes := []synthNote{{start: 0, end: restLen}}
+		labels := []pitchLabel{{start: 0, end: BENCH_SYNTH_REST_SECONDS}}
+		pos := restLen
+		for i := 0; i < fluteNoteLen; i++ {
+			frequency := musicNote.frequency[i]
+			notes = append(notes, synthNote{start: pos, end: pos + noteLen, frequency: frequency})
+			notes = append(notes, synthNote{start: pos + noteLen, end: pos + noteLen + restLen})
+			labels = append(labels,
+				pitchLabel{start: float64(pos) / sampleRate, end: float64(pos+noteLen) / sampleRate, frequency: frequency * math.Pow(2, detune/1200)},
+				pitchLabel{start: float64(pos+noteLen) / sampleRate, end: float64(pos+noteLen+restLen) / sampleRate})
+			pos += noteLen + restLen
+		}
+		synth := &recorderSynthSource{
+			pacedFeeder:  newPacedFeeder(sampleRate, 1, 0),
+			notes:        notes,
+			vibratoCents: vibrato,
+			detuneCents:  detune,
+			random:       rand.New(rand.NewSource(1)),
+		}
+		samples := make([]float32, pos)
+		synth.fill(samples)
+		clips = append(clips, benchClip{
+			name:       fmt.Sprintf("synth vibrato %g detune %g", vibrato, detune),
+			samples:    samples,
+			sampleRate: sampleRate,
+			labels:     labels,
+		})
+	}
+	return clips
+}
+
+// Index of the label at a time, -1 if the time isn't labelled.
+func labelAt(labels []pitchLabel, seconds float64) int {
+	for i, label := range labels {
+		if seconds >= label.start && seconds < label.end {
+			return i
+		}
+	}
+	return -1
+}
+
+// Runs a clip through the analysis and adds the judgement to the result.
+func benchPitchClip(clip benchClip, options AnalysisOptions, result *pitchBenchResult) {
+	analyzer := newSlidingWindowAnalyzer(clip.sampleRate, options)
+	shown := make([]bool, len(clip.labels)) // The note of the label was already shown.
+	for _, sample := range clip.samples {
+		frame, ok := analyzer.Push(sample)
+		if !ok {
+			continue
+		}
+		centre := float64(frame.sampleTime-int64(options.WindowLen/2)) / clip.sampleRate
+		index := labelAt(clip.labels, centre)
+		if index < 0 {
+			continue
+		}
+		label := clip.labels[index]
+		result.frames++
+		voiced := label.frequency > 0
+		heard := !frame.silent && frame.frequency > 0 && frame.confidence >= options.MinConfidence
+		if voiced == heard {
+			result.voicingRight++
+		}
+		if !voiced {
+			continue
+		}
+		result.voicedFrames++
+		refNote := musicNote.MNFindFluteNoteIndex(label.frequency)
+		if frame.note == refNote {
+			result.notesRight++
+		}
+
+		// The latency is the time the game knew the note, the end of the window.
+		if frame.note == refNote && !shown[index] {
+			shown[index] = true
+			result.latencies = append(result.latencies, float64(frame.sampleTime)/clip.sampleRate-label.start)
+		}
+		if !heard {
+			continue
+		}
+		result.heardFrames++
+		cents := centsBetween(frame.frequency, label.frequency)
+		octaves := math.Round(cents / 1200)
+		result.centsErrors = append(result.centsErrors, math.Abs(cents))
+		switch {
+		case math.Abs(cents) <= BENCH_PITCH_TOLERANCE_CENTS:
+			result.inTolerance++
+		case octaves != 0 && math.Abs(cents-1200*octaves) <= BENCH_PITCH_TOLERANCE_CENTS:
+			result.octaveErrors++
+		}
+	}
+	for i, label := range clip.labels {
+		if label.frequency > 0 {
+			result.notes++
+			if !shown[i] {
+				result.notesMissed++
+			}
+		}
+	}
+}
+
+// Percentage of part in total, 0 without a total.
+func percentOf(part int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+// The bench-pitch command, directory is empty for the synthesized corpus,
+// detectorName empty to compare all the detectors and minConfidence negative
+// for the default of each detector.
+func benchPitch(directory string, detectorName string, minConfidence float64, options AnalysisOptions) error {
+	musicNote.MNnew()
+
+	var clips []benchClip
+	if directory == "" {
+		clips = synthBenchCorpus(float64(YIN_SAMPLING_RATE))
+		fmt.Printf("\n Pitch detection accuracy, %d synthesized recordings\n\n", len(clips))
+	} else {
+		var err error
+		if clips, err = readBenchCorpus(directory); err != nil {
+			return err
+		}
+		fmt.Printf("\n Pitch detection accuracy, %d recordings of %s\n\n", len(clips), directory)
+	}
+	for _, clip := range clips {
+		if err := validateWindowForRange(options.WindowLen, clip.sampleRate); err != nil {
+			return fmt.Errorf("%s: %s", clip.name, err.Error())
+		}
+	}
+
+	names := pitchDetectorNames
+	if detectorName != "" {
+		names = []string{detectorName}
+	}
+	fmt.Printf(" %8s %7s %8s %7s %7s %7s %7s %8s %7s\n", "detector", "frames", "voicing", "<50c", "cents", "octave", "note", "latency", "missed")
+	for _, name := range names {
+		options.PitchDetector = name
+		options.MinConfidence = minConfidence
+		if minConfidence < 0 {
+			options.MinConfidence = defaultMinConfidence(name)
+		}
+		result := pitchBenchResult{}
+		for _, clip := range clips {
+			benchPitchClip(clip, options, &result)
+		}
+		cents, latency := 0.0, 0.0
+		if len(result.centsErrors) > 0 {
+			cents = median(result.centsErrors)
+		}
+		if len(result.latencies) > 0 {
+			latency = median(result.latencies)
+		}
+		fmt.Printf(" %8s %7d %7.1f%% %6.1f%% %7.1f %6.1f%% %6.1f%% %5.0f ms %3d/%-3d\n", name, result.frames,
+			percentOf(result.voicingRight, result.frames), percentOf(result.inTolerance, result.voicedFrames), cents,
+			percentOf(result.octaveErrors, result.heardFrames), percentOf(result.notesRight, result.voicedFrames),
+			1000*latency, result.notesMissed, result.notes)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Pitch detectors.
+//
+// Recorders of different makes, and different rooms, suit different pitch
+// detection algorithms, so the detector is chosen with --pitch or in the
+// configuration file:
+//
+//	yin  YIN, the difference function, the default (yin_fft.go).
+//	mpm  McLeod Pitch Method, the normalised square difference (pitch_mpm.go).
+//	acf  Plain autocorrelation (pitch_acf.go).
+//	hps  Harmonic product spectrum, in the frequency domain (pitch_hps.go).
+//
+// Every detector searches only the frequency range of the flute and answers
+// with the frequency, how confident it is of that frequency and how clear,
+// how periodic, the sound is.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const DEFAULT_PITCH_DETECTOR string = "yin"
+
+// Names of the detectors, in the order of the help.
+var pitchDetectorNames = []string{"yin", "mpm", "acf", "hps"}
+
+// Default --min-confidence of each detector, their confidences aren't on the
+// same scale. YIN only answers below its threshold, so its probability is
+// never less than 1 - YIN_DEFAULT_THRESHOLD, 0.85.
+var pitchDetectorMinConfidence = map[string]float64{"yin": 0.9, "mpm": 0.8, "acf": 0.8, "hps": 0.8}
+
+// Confidence a frame of the detector needs to be given a flute note, by default.
+func defaultMinConfidence(detectorName string) float64 {
+	return pitchDetectorMinConfidence[strings.ToLower(detectorName)]
+}
+
+// Result of a pitch detector for one window.
+type PitchEstimate struct {
+	frequency  float64 // Frequency in Hz, -1 if no pitch was found.
+	confidence float64 // Certainty of the frequency, from 0 to 1.
+	clarity    float64 // How periodic the window is, from 0 (noise) to 1 (a pure tone).
+}
+
+type PitchDetector interface {
+	Name() string
+	// Detects the pitch of a window, of the length the detector was created for.
+	DetectPitch(window []float32) PitchEstimate
+}
+
+// Creates the detector with a name, for windows of windowLen samples and
+// pitches between minFrequency and maxFrequency.
+func newPitchDetector(name string, windowLen int, sampleRate float64, minFrequency float64, maxFrequency float64) (PitchDetector, error) {
+	switch strings.ToLower(name) {
+	case "yin":
+		return newYinDetector(windowLen, sampleRate, minFrequency, maxFrequency), nil
+	case "mpm":
+		return newMPMDetector(windowLen, sampleRate, minFrequency, maxFrequency), nil
+	case "acf":
+		return newACFDetector(windowLen, sampleRate, minFrequency, maxFrequency), nil
+	case "hps":
+		return newHPSDetector(windowLen, sampleRate, minFrequency, maxFrequency), nil
+	}
+	return nil, fmt.Errorf("there is no pitch detector %q, it can be %s", name, strings.Join(pitchDetectorNames, ", "))
+}
+
+// Checks the name of the detector given in the command line or in the configuration.
+func validatePitchDetector(name string) error {
+	_, err := newPitchDetector(name, 256, float64(YIN_SAMPLING_RATE), 400, 1400)
+	return err
+}
+
+//##################
+// YIN.
+
+type yinDetector struct {
+	yin *Yin
+}
+
+func newYinDetector(windowLen int, sampleRate float64, minFrequency float64, maxFrequency float64) *yinDetector {
+	yin := newPitchYin(windowLen, sampleRate)
+	yin.YinSetFrequencyRange(minFrequency, maxFrequency)
+	return &yinDetector{yin: yin}
+}
+
+func (D *yinDetector) Name() string {
+	return "yin"
+}
+
+func (D *yinDetector) DetectPitch(window []float32) PitchEstimate {
+	frequency, probability := findMainFrequency(D.yin, window)
+
+	// The clarity is the lowest normalised difference of the range, even when
+	// it's not below the threshold.
+	lowest := 1.0
+	for tau := D.yin.tauMin; tau < D.yin.tauEnd; tau++ {
+		lowest = math.Min(lowest, D.yin.yinBuffer[tau])
+	}
+	return PitchEstimate{frequency: frequency, confidence: probability, clarity: math.Max(0, 1-lowest)}
+}
+
+//##################
+// Helpers shared by the detectors.
+
+// Autocorrelation of windows of a fixed length, with the FFT.
+type autocorrelator struct {
+	plan *fftPlan
+	data []complex128
+}
+
+func newAutocorrelator(windowLen int) *autocorrelator {
+	// Padded to twice the length, so the correlation doesn't wrap around.
+	plan := newFFTPlan(2 * windowLen)
+	return &autocorrelator{plan: plan, data: make([]complex128, plan.size)}
+}
+
+// Fills r with sum x[i] * x[i+tau] over the window, for every tau < len(r).
+func (A *autocorrelator) Compute(window []float32, r []float64) {
+	for i := range A.data {
+		A.data[i] = 0
+		if i < len(window) {
+			A.data[i] = complex(float64(window[i]), 0)
+		}
+	}
+	A.plan.Transform(A.data, false)
+	for k, value := range A.data {
+		A.data[k] = complex(real(value)*real(value)+imag(value)*imag(value), 0)
+	}
+	A.plan.Transform(A.data, true)
+	for tau := range r {
+		r[tau] = real(A.data[tau]) / float64(A.plan.size)
+	}
+}
+
+// Shift of the vertex of the parabola through the values at x - 1, x and x + 1,
+// from -0.5 to 0.5, to find a peak between two samples.
+func parabolicPeakOffset(before float64, at float64, after float64) float64 {
+	denominator := before - 2*at + after
+	if denominator == 0 {
+		return 0
+	}
+	return math.Max(-0.5, math.Min(0.5, (before-after)/(2*denominator)))
+}
+
+// Range of shifts, in samples, of the periods between minFrequency and maxFrequency.
+func tauRange(sampleRate float64, minFrequency float64, maxFrequency float64, maxTau int) (tauMin int, tauEnd int) {
+	tauMin = int(math.Max(2, math.Floor(sampleRate/maxFrequency)))
+	tauEnd = int(math.Min(float64(maxTau), math.Ceil(sampleRate/minFrequency)+2))
+	return tauMin, tauEnd
+}
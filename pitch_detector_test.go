@@ -0,0 +1,17 @@
+// Tests of the choice of the pitch detector.
+
+package main
+
+import "testing"
+
+func TestDefaultMinConfidence(t *testing.T) {
+	for _, name := range pitchDetectorNames {
+		if minConfidence := defaultMinConfidence(name); minConfidence <= 0 || minConfidence > 1 {
+			t.Errorf("%s: default minimum confidence %g, want from 0 to 1", name, minConfidence)
+		}
+	}
+	// YIN is never less confident than that, below it the default wouldn't reject any frame.
+	if minConfidence := defaultMinConfidence("YIN"); minConfidence <= 1-YIN_DEFAULT_THRESHOLD {
+		t.Errorf("yin: default minimum confidence %g, want above %g", minConfidence, 1-YIN_DEFAULT_THRESHOLD)
+	}
+}
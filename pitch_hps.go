@@ -0,0 +1,118 @@
+// Harmonic product spectrum.
+//
+// In the spectrum of the window the fundamental and its harmonics are peaks
+// at f, 2f, 3f... Multiplying the spectrum by itself compressed by 2, 3...
+// lines the harmonics up over the fundamental, the product is highest there.
+// It works in the frequency domain, so it's the least disturbed by a room that
+// echoes, but it needs the harmonics to be there.
+
+package main
+
+import (
+	"math"
+)
+
+const (
+	HPS_HARMONICS      int     = 3   // Harmonics multiplied, the fundamental included.
+	HPS_PADDING        int     = 4   // The window is padded with zeros to this many times its length, for finer bins.
+	HPS_MIN_CONFIDENCE float64 = 0.5 // Below this fraction of the energy in the harmonics there is no pitch.
+)
+
+type hpsDetector struct {
+	sampleRate float64
+	plan       *fftPlan
+	data       []complex128
+	hann       []float64 // Window function, to keep the peaks narrow.
+	power      []float64 // Power spectrum, up to half the sample rate.
+	binMin     int       // Bins of the fundamental searched.
+	binEnd     int
+}
+
+func newHPSDetector(windowLen int, sampleRate float64, minFrequency float64, maxFrequency float64) *hpsDetector {
+	plan := newFFTPlan(HPS_PADDING * windowLen)
+	hann := make([]float64, windowLen)
+	for i := range hann {
+		hann[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(windowLen))
+	}
+	binHz := sampleRate / float64(plan.size)
+	power := make([]float64, plan.size/2)
+	return &hpsDetector{
+		sampleRate: sampleRate,
+		plan:       plan,
+		data:       make([]complex128, plan.size),
+		hann:       hann,
+		power:      power,
+		binMin:     int(math.Max(1, math.Floor(minFrequency/binHz))),
+		binEnd:     int(math.Min(float64(len(power)/HPS_HARMONICS), math.Ceil(maxFrequency/binHz)+1)),
+	}
+}
+
+func (D *hpsDetector) Name() string {
+	return "hps"
+}
+
+func (D *hpsDetector) DetectPitch(window []float32) PitchEstimate {
+	estimate := PitchEstimate{frequency: -1, confidence: 0, clarity: 0}
+	for i := range D.data {
+		D.data[i] = 0
+		if i < len(window) {
+			D.data[i] = complex(float64(window[i])*D.hann[i], 0)
+		}
+	}
+	D.plan.Transform(D.data, false)
+	total := 0.0
+	for k := range D.power {
+		value := D.data[k]
+		D.power[k] = real(value)*real(value) + imag(value)*imag(value)
+		total += D.power[k]
+	}
+	if total == 0 || D.binEnd <= D.binMin+1 {
+		return estimate
+	}
+
+	// The product as a sum of logarithms, it doesn't underflow.
+	const tiny = 1e-20
+	best := -1
+	bestValue := math.Inf(-1)
+	for k := D.binMin; k < D.binEnd; k++ {
+		value := 0.0
+		for h := 1; h <= HPS_HARMONICS; h++ {
+			value += math.Log(D.power[h*k] + tiny)
+		}
+		if value > bestValue {
+			best, bestValue = k, value
+		}
+	}
+
+	// Fraction of the energy near the harmonics of the best fundamental, the
+	// main lobe of the Hann window is two bins of the window without padding.
+	lobe := 2 * HPS_PADDING
+	harmonicEnergy := 0.0
+	for h := 1; h <= HPS_HARMONICS; h++ {
+		for k := h*best - lobe; k <= h*best+lobe; k++ {
+			if k >= 0 && k < len(D.power) {
+				harmonicEnergy += D.power[k]
+			}
+		}
+	}
+
+	// The clarity is one minus the flatness of the spectrum where the notes are,
+	// a flat spectrum is noise.
+	logSum := 0.0
+	sum := 0.0
+	for k := D.binMin; k < HPS_HARMONICS*D.binEnd; k++ {
+		logSum += math.Log(D.power[k] + tiny)
+		sum += D.power[k]
+	}
+	bins := float64(HPS_HARMONICS*D.binEnd - D.binMin)
+	estimate.clarity = math.Max(0, 1-math.Exp(logSum/bins)/(sum/bins+tiny))
+
+	confidence := harmonicEnergy / total
+	if confidence >= HPS_MIN_CONFIDENCE {
+		// The peak of the fundamental itself, between bins.
+		offset := parabolicPeakOffset(math.Log(D.power[best-1]+tiny), math.Log(D.power[best]+tiny), math.Log(D.power[best+1]+tiny))
+		estimate.frequency = (float64(best) + offset) * D.sampleRate / float64(D.plan.size)
+		estimate.confidence = confidence
+	}
+	return estimate
+}
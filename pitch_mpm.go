@@ -0,0 +1,101 @@
+// McLeod Pitch Method.
+//
+// The normalised square difference of the window with itself shifted by tau,
+//
+//	n(tau) = 2 * sum x[i] * x[i+tau] / sum (x[i]^2 + x[i+tau]^2)
+//
+// goes from -1 to 1, 1 is a perfect repetition after tau samples. The peaks
+// of n between its zero crossings are the candidate periods, the first that
+// comes close to the highest is the pitch, so the octaves below it, that
+// repeat just as well, aren't chosen. See McLeod and Wyvill, "A smarter way
+// to find pitch", 2005.
+
+package main
+
+// Fraction of the highest peak the chosen peak must reach.
+const MPM_PEAK_THRESHOLD float64 = 0.9
+
+// Below this peak there is no pitch, the window is mostly noise.
+const MPM_MIN_CLARITY float64 = 0.5
+
+type mpmDetector struct {
+	sampleRate     float64
+	tauMin         int
+	tauEnd         int
+	autocorrelator *autocorrelator
+	correlation    []float64
+	nsdf           []float64
+	energy         []float64 // Running sum of the squares of the window.
+}
+
+func newMPMDetector(windowLen int, sampleRate float64, minFrequency float64, maxFrequency float64) *mpmDetector {
+	tauMin, tauEnd := tauRange(sampleRate, minFrequency, maxFrequency, windowLen/2)
+	return &mpmDetector{
+		sampleRate:     sampleRate,
+		tauMin:         tauMin,
+		tauEnd:         tauEnd,
+		autocorrelator: newAutocorrelator(windowLen),
+		correlation:    make([]float64, tauEnd+1),
+		nsdf:           make([]float64, tauEnd+1),
+		energy:         make([]float64, windowLen+1),
+	}
+}
+
+func (D *mpmDetector) Name() string {
+	return "mpm"
+}
+
+func (D *mpmDetector) DetectPitch(window []float32) PitchEstimate {
+	D.autocorrelator.Compute(window, D.correlation)
+	for i, sample := range window {
+		D.energy[i+1] = D.energy[i] + float64(sample)*float64(sample)
+	}
+	n := len(window)
+	for tau := range D.nsdf {
+		squares := D.energy[n-tau] + D.energy[n] - D.energy[tau]
+		D.nsdf[tau] = 0
+		if squares > 0 {
+			D.nsdf[tau] = 2 * D.correlation[tau] / squares
+		}
+	}
+
+	// The highest point of every positive part of n, after the first time it
+	// goes below zero, that leaves out the peak at tau 0.
+	peaks := []int{}
+	highest := 0.0
+	peak := -1
+	for tau := 1; tau < D.tauEnd; tau++ {
+		if D.nsdf[tau-1] >= 0 && D.nsdf[tau] < 0 {
+			if peak > 0 {
+				peaks = append(peaks, peak)
+			}
+			peak = 0
+		}
+		if peak == -1 || D.nsdf[tau] < 0 || tau < D.tauMin {
+			continue
+		}
+		if peak == 0 || D.nsdf[tau] > D.nsdf[peak] {
+			peak = tau
+		}
+		if D.nsdf[tau] > highest {
+			highest = D.nsdf[tau]
+		}
+	}
+	if peak > 0 && peak < D.tauEnd-1 {
+		// The last part, if the peak isn't cut by the end of the range.
+		peaks = append(peaks, peak)
+	}
+
+	estimate := PitchEstimate{frequency: -1, confidence: 0, clarity: highest}
+	for _, tau := range peaks {
+		if D.nsdf[tau] >= MPM_PEAK_THRESHOLD*highest {
+			if D.nsdf[tau] >= MPM_MIN_CLARITY {
+				offset := parabolicPeakOffset(D.nsdf[tau-1], D.nsdf[tau], D.nsdf[tau+1])
+				estimate.frequency = D.sampleRate / (float64(tau) + offset)
+				estimate.confidence = D.nsdf[tau]
+			}
+			break
+		}
+	}
+	return estimate
+}
@@ -0,0 +1,157 @@
+// Synthetic recorder.
+//
+// Plays a MusicScore with a sound that resembles a recorder, so a whole song
+// can go through the pitch detection and the scoring without an instrument.
+// The notes are played exactly at the time they cross the score line, like
+// a perfect player would do, so a run without detune should score 100%.
+//
+// The timbre is built from:
+//   - additive harmonics, a strong fundamental with weak upper partials;
+//   - breath noise, always present while a note sounds;
+//   - an attack chiff, a short burst of noise and second harmonic at the
+//     start of each note, like the tongue releasing the air;
+//   - optional vibrato and a fixed detune, both in cents.
+
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Relative amplitude of the harmonics of the recorder sound, the first is the fundamental.
+var recorderHarmonics = []float64{1.0, 0.12, 0.18, 0.04, 0.05}
+
+const (
+	SYNTH_AMPLITUDE       float64 = 0.4   // Peak amplitude of the fundamental.
+	SYNTH_BREATH_NOISE    float64 = 0.01  // Amplitude of the breath noise.
+	SYNTH_CHIFF_SECONDS   float64 = 0.015 // Duration of the attack chiff.
+	SYNTH_CHIFF_NOISE     float64 = 0.08  // Amplitude of the noise in the chiff.
+	SYNTH_ATTACK_SECONDS  float64 = 0.01  // Rise time of the note.
+	SYNTH_RELEASE_SECONDS float64 = 0.01  // Fall time at the end of the note.
+	SYNTH_VIBRATO_RATE_HZ float64 = 5.0   // Speed of the vibrato.
+)
+
+// One note of the timeline, in samples from the start of the audio.
+type synthNote struct {
+	start     int
+	end       int
+	frequency float64 // 0 for a silence.
+}
+
+type recorderSynthSource struct {
+	pacedFeeder
+	notes        []synthNote
+	vibratoCents float64
+	detuneCents  float64
+	random       *rand.Rand
+	sampleIndex  int // Index of the next sample.
+	noteIndex    int // Index in notes of the note being played.
+	phase        float64
+	noiseLowPass float64 // State of the filter that softens the breath noise.
+}
+
+// Creates a source that plays the music score once, starting with the silence
+// while the first note scrolls to the score line.
+func newRecorderSynthSource(MS *MusicScore, MN *MusicNote, sampleRate int, vibratoCents float64, detuneCents float64, speed float64) *recorderSynthSource {
+	stepLen := gameStepLenAt(float64(sampleRate))
+
+	// The score starts at the right edge of the screen and each game step
+	// moves it one column to the left, until it reaches the line at column 10.
+	// MSUpdateMovement already advances the score on the step it reaches the
+	// line, so the first column is judged one step earlier than that.
+	pos := (MAX_SCREEN_WIDE - 10 - 1) * stepLen
+	notes := []synthNote{{start: 0, end: pos, frequency: 0}}
+	for _, e := range MS.NotesList {
+		frequency := 0.0
+		if e.Note != EMPTY {
+			frequency = MN.frequency[e.Note]
+		}
+		end := pos + e.Duration*stepLen
+		notes = append(notes, synthNote{start: pos, end: end, frequency: frequency})
+		pos = end
+	}
+	// Two silent steps at the end, so the last note is judged before the audio ends.
+	notes = append(notes, synthNote{start: pos, end: pos + 2*stepLen, frequency: 0})
+
+	return &recorderSynthSource{
+		pacedFeeder:  newPacedFeeder(float64(sampleRate), 1, speed),
+		notes:        notes,
+		vibratoCents: vibratoCents,
+		detuneCents:  detuneCents,
+		random:       rand.New(rand.NewSource(1)), // Always the same noise, runs are reproducible.
+	}
+}
+
+func (R *recorderSynthSource) Start(handler AudioFrameHandler) error {
+	go R.run(handler, R.fill)
+	return nil
+}
+
+// Fills the block with the next samples of the timeline, returns how many
+// were written, 0 at the end.
+func (R *recorderSynthSource) fill(block []float32) int {
+	n := 0
+	for n < len(block) && R.noteIndex < len(R.notes) {
+		note := R.notes[R.noteIndex]
+		if R.sampleIndex >= note.end {
+			R.noteIndex++
+			R.phase = 0
+			continue
+		}
+		block[n] = float32(R.nextSample(note))
+		R.sampleIndex++
+		n++
+	}
+	return n
+}
+
+// Generates the sample at R.sampleIndex inside the note.
+func (R *recorderSynthSource) nextSample(note synthNote) float64 {
+	if note.frequency == 0 {
+		return 0
+	}
+
+	t := float64(R.sampleIndex-note.start) / R.sampleRate
+	tillEnd := float64(note.end-R.sampleIndex) / R.sampleRate
+
+	// Pitch with detune and vibrato.
+	cents := R.detuneCents + R.vibratoCents*math.Sin(2*math.Pi*SYNTH_VIBRATO_RATE_HZ*t)
+	frequency := note.frequency * math.Pow(2, cents/1200)
+	R.phase = math.Mod(R.phase+2*math.Pi*frequency/R.sampleRate, 2*math.Pi)
+
+	// Envelope, a linear rise and fall.
+	envelope := 1.0
+	if t < SYNTH_ATTACK_SECONDS {
+		envelope = t / SYNTH_ATTACK_SECONDS
+	}
+	if tillEnd < SYNTH_RELEASE_SECONDS {
+		envelope = math.Min(envelope, tillEnd/SYNTH_RELEASE_SECONDS)
+	}
+
+	// Chiff, fades out during the first milliseconds of the note.
+	chiff := 0.0
+	if t < SYNTH_CHIFF_SECONDS {
+		chiff = 1 - t/SYNTH_CHIFF_SECONDS
+	}
+
+	value := 0.0
+	for h, amplitude := range recorderHarmonics {
+		if h == 1 {
+			// The second harmonic speaks first in the chiff.
+			amplitude += 0.3 * chiff
+		}
+		value += amplitude * math.Sin(float64(h+1)*R.phase)
+	}
+	value *= SYNTH_AMPLITUDE
+
+	// Breath noise, white noise through a one pole low pass filter.
+	R.noiseLowPass += 0.3 * (R.random.Float64()*2 - 1 - R.noiseLowPass)
+	value += R.noiseLowPass * (SYNTH_BREATH_NOISE + SYNTH_CHIFF_NOISE*chiff)
+
+	return value * envelope
+}
+
+func (R *recorderSynthSource) Close() error {
+	return R.Stop()
+}
@@ -0,0 +1,131 @@
+// Tests of the game played by the synthetic recorder, the whole pipeline from
+// the audio to the score.
+
+package main
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Analysis options with the defaults of the command line.
+func defaultAnalysisOptions() AnalysisOptions {
+	return AnalysisOptions{
+		WindowLen:           DEFAULT_WINDOW_LEN,
+		HopLen:              DEFAULT_HOP_LEN,
+		GateThresholdDB:     DEFAULT_GATE_THRESHOLD_DB,
+		GateAttack:          DEFAULT_GATE_ATTACK,
+		GateRelease:         DEFAULT_GATE_RELEASE,
+		PitchDetector:       DEFAULT_PITCH_DETECTOR,
+		MinConfidence:       defaultMinConfidence(DEFAULT_PITCH_DETECTOR),
+		MedianFrames:        DEFAULT_MEDIAN_FRAMES,
+		NoteHysteresisCents: DEFAULT_NOTE_HYSTERESIS_CENTS,
+		MinNoteDuration:     DEFAULT_MIN_NOTE_DURATION,
+	}
+}
+
+// Plays a song with the synthesizer through the game, as fast as possible,
+// with every note shiftSeconds late, early if negative. The score is left in
+// the current counters.
+func playSynthSong(t *testing.T, song string, options AnalysisOptions, detuneCents float64, shiftSeconds float64) {
+	// The game screens aren't needed.
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = devNull
+	defer func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}()
+
+	if strings.HasSuffix(song, ".ABC") {
+		music_01 = getReadMusicScoreFromSimplifiedABC(song)
+	} else {
+		music_01 = getReadMusicScoreFromJSON(song)
+	}
+	music_01.MSResetToRepeat()
+	gameInit()
+	currentScore, currentHits, currentMisses, currentUncertain = 0, 0, 0, 0
+	currentNotTongued, currentOutOfTune, currentSqueaks, currentBadlyOutOfTune = 0, 0, 0, 0
+
+	source := newRecorderSynthSource(&music_01, &musicNote, YIN_SAMPLING_RATE, 0, detuneCents, 0)
+	shift := int(shiftSeconds * float64(YIN_SAMPLING_RATE))
+	for i := 1; i < len(source.notes); i++ {
+		source.notes[i-1].end += shift
+		source.notes[i].start += shift
+	}
+	pipeline := newAudioPipeline(source, options)
+	pipeline.Start()
+	if err := source.Start(pipeline.FrameHandler()); err != nil {
+		t.Fatal(err)
+	}
+	<-source.Done()
+	pipeline.Finish()
+}
+
+func TestRecorderSynthHitsEveryNote(t *testing.T) {
+	for _, detector := range pitchDetectorNames {
+		for _, song := range []string{"music_01.json", "music_02.json", "music_03.ABC"} {
+			t.Run(detector+"/"+song, func(t *testing.T) {
+				options := defaultAnalysisOptions()
+				options.PitchDetector = detector
+				options.MinConfidence = defaultMinConfidence(detector)
+				playSynthSong(t, song, options, 0, 0)
+				if currentHits == 0 || currentMisses != 0 || currentUncertain != 0 {
+					t.Errorf("%d hits, %d misses, %d uncertain, want every note hit", currentHits, currentMisses, currentUncertain)
+				}
+				if currentOutOfTune != 0 || currentNotTongued != 0 {
+					t.Errorf("%d out of tune, %d not tongued, want none", currentOutOfTune, currentNotTongued)
+				}
+				if currentScore != 10*currentHits {
+					t.Errorf("score %d, want %d", currentScore, 10*currentHits)
+				}
+			})
+		}
+	}
+}
+
+func TestRecorderSynthOutOfTune(t *testing.T) {
+	tests := []struct {
+		detuneCents float64
+		hits        bool // Every note hit, else every note missed.
+		outOfTune   bool // Every hit out of tune.
+	}{
+		{-15, true, false},
+		{35, true, true},
+		{-45, true, true},
+		{70, false, false},
+	}
+	for _, test := range tests {
+		playSynthSong(t, "music_01.json", defaultAnalysisOptions(), test.detuneCents, 0)
+		notes := currentHits + currentMisses
+		if test.hits && currentHits != notes || !test.hits && currentMisses != notes {
+			t.Errorf("detune %g: %d hits, %d misses", test.detuneCents, currentHits, currentMisses)
+		}
+		if test.outOfTune && currentOutOfTune != currentHits || !test.outOfTune && currentOutOfTune != 0 {
+			t.Errorf("detune %g: %d out of tune of %d hits", test.detuneCents, currentOutOfTune, currentHits)
+		}
+		if !test.hits && currentBadlyOutOfTune == 0 {
+			t.Errorf("detune %g: no note badly out of tune", test.detuneCents)
+		}
+	}
+}
+
+func TestRecorderSynthTiming(t *testing.T) {
+	for _, shiftSeconds := range []float64{-0.1, -0.06, -0.03, 0.03, 0.06, 0.1} {
+		for _, song := range []string{"music_01.json", "music_03.ABC"} {
+			playSynthSong(t, song, defaultAnalysisOptions(), 0, shiftSeconds)
+			if currentNotTongued != 0 {
+				t.Errorf("%s %+gs: %d of %d hits not tongued, want none", song, shiftSeconds, currentNotTongued, currentHits)
+			}
+			// A note 0.1s late is still the note before for most of its first step.
+			if math.Abs(shiftSeconds) < 0.1 && (currentMisses != 0 || currentUncertain != 0) {
+				t.Errorf("%s %+gs: %d misses, %d uncertain, want every note hit", song, shiftSeconds, currentMisses, currentUncertain)
+			}
+		}
+	}
+}
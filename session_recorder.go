@@ -0,0 +1,177 @@
+// Play session recording.
+//
+// With --record the audio that the game analyses is written to a WAV file
+// and, next to it, a JSON file with the song, the start time, the sample rate,
+// the pitch detected in every analysis window and what the game judged in
+// every step. A teacher can listen to the attempt and follow the game with it.
+//
+// The WAV file has the input mixed down to mono, as the analysis got it. When
+// the game fell behind the microphone some audio was lost and isn't in it,
+// the gaps of the JSON file tell where and how long, so a replay of the WAV
+// file is only the same as the game when there are none.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The session being recorded, nil when not recording. The samples and pitch
+// frames are added by the analysis goroutine, the steps by the render goroutine.
+var session *sessionRecorder = nil
+
+type SessionPitchFrame struct {
+	Time       float64 `json:"time"`              // Seconds from the start, at the end of the analysis window.
+	Frequency  float64 `json:"frequency"`         // Frequency in Hz after the octave correction, -1 if none was found.
+	Detected   float64 `json:"detectedFrequency"` // Frequency in Hz the detector found.
+	Confidence float64 `json:"confidence"`
+	Clarity    float64 `json:"clarity"`
+	RMS        float64 `json:"rms"`               // RMS level of the window in dBFS.
+	Peak       float64 `json:"peak"`              // Peak level of the window in dBFS.
+	Silent     bool    `json:"silent"`            // The noise gate was closed.
+	RawNote    int     `json:"rawNote"`           // Note of the frame alone, -1 silence, -2 uncertain, -3 squeak.
+	Note       int     `json:"note"`              // Note from the note tracker.
+	Smoothed   float64 `json:"smoothedFrequency"` // Median filtered frequency, -1 without a note.
+	Squeak     bool    `json:"squeak"`            // The flute was overblown.
+}
+
+type SessionStep struct {
+	Time      float64 `json:"time"`      // Seconds from the start, at the end of the game step.
+	Frequency float64 `json:"frequency"` // Frequency the game used for the step.
+	Note      int     `json:"note"`      // Flute note played, same codes as the music score, -1 for silence, -2 uncertain, -3 squeak.
+	Cents     float64 `json:"cents"`     // Cents of the frequency from the note.
+	Tuning    string  `json:"tuning"`    // In tune, sharp, flat or unknown, see MNNoteStatus.
+	Score     int     `json:"score"`     // Score after the step.
+}
+
+type SessionGap struct {
+	Time   float64 `json:"time"`   // Seconds in the WAV file where the audio is missing.
+	Length float64 `json:"length"` // Seconds of audio lost.
+}
+
+type SessionInfo struct {
+	Song          string              `json:"song"`
+	StartTime     time.Time           `json:"startTime"`
+	SampleRate    float64             `json:"sampleRate"`
+	WindowLength  int                 `json:"windowLength"`  // Samples in each analysis window.
+	HopLength     int                 `json:"hopLength"`     // Samples between analysis windows.
+	GateDB        float64             `json:"gateDB"`        // Noise gate threshold in dBFS.
+	Detector      string              `json:"detector"`      // Pitch detection algorithm.
+	MinConfidence float64             `json:"minConfidence"` // Frames less confident are uncertain.
+	Latency       float64             `json:"latency"`       // Input latency in seconds the judgement was shifted by.
+	WavFile       string              `json:"wavFile"`
+	Onsets        []float64           `json:"onsets"`  // Seconds from the start of each note the player started.
+	Squeaks       []float64           `json:"squeaks"` // Seconds from the start of each squeak.
+	Gaps          []SessionGap        `json:"gaps"`    // Audio lost because the game fell behind.
+	Frames        []SessionPitchFrame `json:"frames"`
+	Steps         []SessionStep       `json:"steps"`
+}
+
+type sessionRecorder struct {
+	wav         *wavFileWriter
+	writeErr    error // First error writing the WAV file, it stops the writing.
+	sidecarPath string
+	info        SessionInfo
+	squeaking   bool // The last frame was a squeak.
+}
+
+// Creates the WAV file, the JSON sidecar has the same name with a .json extension.
+func newSessionRecorder(wavFilePathAndName string, songName string, sampleRate float64, options AnalysisOptions) (*sessionRecorder, error) {
+	wav, err := createWavFile(wavFilePathAndName, int(sampleRate), 1)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionRecorder{
+		wav:         wav,
+		sidecarPath: strings.TrimSuffix(wavFilePathAndName, filepath.Ext(wavFilePathAndName)) + ".json",
+		info: SessionInfo{
+			Song:          songName,
+			StartTime:     time.Now(),
+			SampleRate:    sampleRate,
+			WindowLength:  options.WindowLen,
+			HopLength:     options.HopLen,
+			GateDB:        options.GateThresholdDB,
+			Detector:      options.PitchDetector,
+			MinConfidence: options.MinConfidence,
+			Latency:       options.InputLatency.Seconds(),
+			WavFile:       filepath.Base(wavFilePathAndName),
+			Onsets:        []float64{},
+			Squeaks:       []float64{},
+			Gaps:          []SessionGap{},
+			Frames:        []SessionPitchFrame{},
+			Steps:         []SessionStep{},
+		},
+	}, nil
+}
+
+func (S *sessionRecorder) WriteSamples(samples []float32) {
+	if S.writeErr == nil {
+		S.writeErr = S.wav.Write(samples)
+	}
+}
+
+// Adds audio lost after position samples, a gap at the same position makes the last one longer.
+func (S *sessionRecorder) AddGap(position uint64, length int) {
+	start := float64(position) / S.info.SampleRate
+	seconds := float64(length) / S.info.SampleRate
+	if last := len(S.info.Gaps) - 1; last >= 0 && S.info.Gaps[last].Time == start {
+		S.info.Gaps[last].Length += seconds
+		return
+	}
+	S.info.Gaps = append(S.info.Gaps, SessionGap{Time: start, Length: seconds})
+}
+
+func (S *sessionRecorder) AddPitchFrame(frame PitchFrame) {
+	if frame.onset {
+		S.info.Onsets = append(S.info.Onsets, float64(frame.onsetTime)/S.info.SampleRate)
+	}
+	if frame.squeak && !S.squeaking {
+		S.info.Squeaks = append(S.info.Squeaks, float64(frame.sampleTime)/S.info.SampleRate)
+	}
+	S.squeaking = frame.squeak
+	S.info.Frames = append(S.info.Frames, SessionPitchFrame{
+		Time:       float64(frame.sampleTime) / S.info.SampleRate,
+		Frequency:  frame.frequency,
+		Detected:   frame.detectedFrequency,
+		Confidence: frame.confidence,
+		Clarity:    frame.clarity,
+		RMS:        frame.rmsDB,
+		Peak:       frame.peakDB,
+		Silent:     frame.silent,
+		RawNote:    frame.rawNote,
+		Note:       frame.note,
+		Smoothed:   frame.smoothedFrequency,
+		Squeak:     frame.squeak,
+	})
+}
+
+func (S *sessionRecorder) AddStep(frame AnalysisFrame, playedNote int, cents float64, status int, score int) {
+	S.info.Steps = append(S.info.Steps, SessionStep{
+		Time:      float64(frame.sampleTime) / S.info.SampleRate,
+		Frequency: frame.frequency,
+		Note:      playedNote,
+		Cents:     cents,
+		Tuning:    noteStatusNames[status],
+		Score:     score,
+	})
+}
+
+// Finishes the WAV file and writes the JSON sidecar, after the pipeline finished.
+func (S *sessionRecorder) Close() error {
+	err := S.writeErr
+	if closeErr := S.wav.Close(); err == nil {
+		err = closeErr
+	}
+	raw, jsonErr := json.MarshalIndent(S.info, "", "  ")
+	if jsonErr == nil {
+		jsonErr = ioutil.WriteFile(S.sidecarPath, raw, 0644)
+	}
+	if err == nil {
+		err = jsonErr
+	}
+	return err
+}
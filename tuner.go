@@ -0,0 +1,194 @@
+// Chromatic tuner.
+//
+// Before playing songs a student must learn to hold a steady note, in tune.
+// The tune command skips the music score and shows, many times a second,
+// the frequency played, the nearest note of the chromatic scale, and the
+// flute note when it's one, how many cents it's sharp or flat, the
+// confidence of the pitch and how steady it was in the last second:
+//
+//	galileu_flute.exe tune
+//
+// It uses the same audio input and analysis as the game, so --input,
+// --device, --pitch and the noise gate work the same.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+const (
+	TUNER_STEP_SECONDS     float64 = 0.1 // Time between two updates of the tuner.
+	TUNER_IN_TUNE_CENTS    float64 = 10  // Closer than this to the note is in tune.
+	TUNER_STEADY_CENTS     float64 = 10  // Less spread than this, in cents, is a steady note.
+	TUNER_STEADY_SECONDS   float64 = 1.0 // Time the steadiness is measured in.
+	TUNER_BAR_HALF_WIDTH   int     = 20  // Characters of the cents bar on each side of the centre.
+	TUNER_BAR_RANGE_CENTS  float64 = 50  // Cents at the ends of the bar.
+	TUNER_CONFIDENCE_WIDTH int     = 10  // Characters of the confidence bar.
+)
+
+// Names of the notes of the chromatic scale, from C.
+var chromaticNoteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// The tuner, nil while playing the game.
+var tuner *chromaticTuner = nil
+
+type chromaticTuner struct {
+	a4Frequency   float64
+	minConfidence float64   // Less confident pitches aren't clear.
+	recent        []float64 // Cents from the nearest note of the last updates, circular.
+	recentLen     int
+	recentPos     int
+	lastName      string // Nearest note of the last update, the steadiness restarts on a new note.
+}
+
+func newChromaticTuner(a4Frequency float64, minConfidence float64) *chromaticTuner {
+	return &chromaticTuner{
+		a4Frequency:   a4Frequency,
+		minConfidence: minConfidence,
+		recent:        make([]float64, int(math.Max(1, math.Round(TUNER_STEADY_SECONDS/TUNER_STEP_SECONDS)))),
+	}
+}
+
+// Nearest note of the chromatic scale to a frequency, like A4, and how many
+// cents the frequency is above it, negative when below.
+func nearestChromaticNote(frequency float64, a4Frequency float64) (name string, cents float64) {
+	semitones := 12 * math.Log2(frequency/a4Frequency)
+	nearest := math.Round(semitones)
+	// A4 is 9 semitones above C4, the notes are counted from C0.
+	fromC0 := int(nearest) + 9 + 4*12
+	octave := int(math.Floor(float64(fromC0) / 12))
+	name = fmt.Sprintf("%s%d", chromaticNoteNames[fromC0-12*octave], octave)
+	return name, 100 * (semitones - nearest)
+}
+
+// Bar of the cents, the needle '^' is left of the centre when flat.
+func tunerCentsBar(cents float64) string {
+	clamped := math.Max(-TUNER_BAR_RANGE_CENTS, math.Min(TUNER_BAR_RANGE_CENTS, cents))
+	needle := TUNER_BAR_HALF_WIDTH + int(math.Round(clamped/TUNER_BAR_RANGE_CENTS*float64(TUNER_BAR_HALF_WIDTH)))
+	var bar strings.Builder
+	for i := 0; i <= 2*TUNER_BAR_HALF_WIDTH; i++ {
+		switch {
+		case i == needle:
+			bar.WriteRune('^')
+		case i == TUNER_BAR_HALF_WIDTH:
+			bar.WriteRune('|')
+		default:
+			bar.WriteRune('-')
+		}
+	}
+	return bar.String()
+}
+
+// Spread of the cents of the last updates, their standard deviation.
+func (T *chromaticTuner) spread() float64 {
+	values := T.recent[:T.recentLen]
+	mean := 0.0
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+	return math.Sqrt(variance / float64(len(values)))
+}
+
+// Shows one update of the tuner, in the render goroutine.
+func (T *chromaticTuner) Render(frame AnalysisFrame) {
+	fmt.Printf("\n\n\n\n                Galileu's Flute - Tuner\n\n")
+	// Any clear pitch is shown, not only the notes of the flute.
+	clear := frame.frequency > 0 && frame.confidence >= T.minConfidence
+	if frame.note == SILENCE || frame.note == SQUEAK || !clear {
+		T.recentLen = 0
+		T.lastName = ""
+		switch frame.note {
+		case SILENCE:
+			fmt.Printf(" Play a long note...\n\n\n\n\n")
+		case SQUEAK:
+			fmt.Printf(" Squeak! Blow softer.\n\n\n\n\n")
+		default:
+			fmt.Printf(" ?  The note isn't clear.\n\n\n\n\n")
+		}
+		fmt.Printf(" Level %4.0f dB\n", math.Max(frame.rmsDB, LEVEL_METER_MIN_DB))
+		printDroppedAudio()
+		return
+	}
+
+	name, cents := nearestChromaticNote(frame.frequency, T.a4Frequency)
+	if name != T.lastName {
+		T.recentLen = 0
+		T.recentPos = 0
+		T.lastName = name
+	}
+	T.recent[T.recentPos] = cents
+	T.recentPos = (T.recentPos + 1) % len(T.recent)
+	if T.recentLen < len(T.recent) {
+		T.recentLen++
+	}
+
+	flute := ""
+	if match := musicNote.MNMatchNote(frame.frequency); match.status != NOTE_UNKNOWN {
+		flute = "  flute " + fluteNoteNames[match.note]
+	}
+	verdict := "in tune"
+	if cents > TUNER_IN_TUNE_CENTS {
+		verdict = "sharp, lower it"
+	} else if cents < -TUNER_IN_TUNE_CENTS {
+		verdict = "flat, raise it"
+	}
+	confidence := int(math.Round(math.Max(0, math.Min(1, frame.confidence)) * float64(TUNER_CONFIDENCE_WIDTH)))
+	steadiness := "steady"
+	if T.recentLen < len(T.recent) {
+		steadiness = "hold it..."
+	} else if T.spread() > TUNER_STEADY_CENTS {
+		steadiness = "wavering"
+	}
+
+	fmt.Printf(" %8.2f Hz   %-4s%s\n\n", frame.frequency, name, flute)
+	fmt.Printf("  flat [%s] sharp\n", tunerCentsBar(cents))
+	fmt.Printf(" %+5.0f cents, %s\n", cents, verdict)
+	fmt.Printf(" Confidence [%s%s] %.2f\n", strings.Repeat("#", confidence), strings.Repeat(".", TUNER_CONFIDENCE_WIDTH-confidence), frame.confidence)
+	fmt.Printf(" Spread %4.1f cents, %s\n", T.spread(), steadiness)
+	fmt.Printf(" Level %4.0f dB\n", math.Max(frame.rmsDB, LEVEL_METER_MIN_DB))
+	printDroppedAudio()
+}
+
+// The tune command, runs the tuner until the input ends or Ctrl + C.
+func runTuner(inputOptions AudioInputOptions, options AnalysisOptions) error {
+	source, err := openAudioSource(inputOptions)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	if err := validateWindowForRange(options.WindowLen, source.SampleRate()); err != nil {
+		return err
+	}
+
+	tuner = newChromaticTuner(tuningA4Frequency, options.MinConfidence)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	// The tuner shows the pitch more often than the game steps.
+	options.StepSeconds = TUNER_STEP_SECONDS
+	pipeline := newAudioPipeline(source, options)
+	pipeline.Start()
+	if err := source.Start(pipeline.FrameHandler()); err != nil {
+		return err
+	}
+	select {
+	case <-source.Done():
+		fmt.Printf("\n\nEnd of the audio input.\n")
+	case <-interrupt:
+	}
+	if err := source.Stop(); err != nil {
+		return err
+	}
+	pipeline.Finish()
+	return source.Err()
+}
@@ -0,0 +1,145 @@
+// Tuning of the flute notes.
+//
+// The frequencies of the notes are those of equal temperament, computed from
+// the reference pitch of A4. Most recorders are made for A4 at 440 Hz, the
+// baroque ones for 415 Hz and some orchestras tune to 442 or 443 Hz:
+//
+//	galileu_flute.exe --a4 442 ./music_01.json
+//
+// The songs are written in the fingerings of the soprano recorder, DO with
+// all the holes covered. The other recorders use the same fingerings, but
+// sound higher or lower, the instrument moves all the notes by its
+// transposition:
+//
+//	galileu_flute.exe --instrument alto ./music_01.json
+//
+// Both are saved in the configuration file for the next runs, the game tells
+// it at the start, --a4 440 and --instrument soprano go back to the defaults.
+//
+// A frequency is matched to the nearest note, with how many cents it's away
+// from it. Closer than the tolerance it's in tune, farther it's sharp or flat,
+// the right fingering played out of tune, and farther than NOTE_MAX_CENTS it's
+// unknown, no note of the flute. The game scores an unknown pitch up to
+// NOTE_BAD_MAX_CENTS from the note expected as a miss, badly out of tune:
+//
+//	galileu_flute.exe --tolerance 15 ./music_01.json
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const DEFAULT_A4_FREQUENCY float64 = 440
+const DEFAULT_INSTRUMENT string = "soprano"
+
+// Range of the reference pitch accepted, from below the baroque 415 Hz to above 443 Hz.
+const MIN_A4_FREQUENCY float64 = 400
+const MAX_A4_FREQUENCY float64 = 466
+
+// Semitones each recorder sounds above the soprano, with the same fingering.
+var instrumentTranspositions = map[string]int{
+	"sopranino": 5,   // In F, DO sounds F5.
+	"soprano":   0,   // In C, DO sounds C5.
+	"alto":      -7,  // In F, DO sounds F4.
+	"tenor":     -12, // In C, DO sounds C4.
+}
+
+// Default cents from a note that are in tune, a profile sets each note, see note_profile.go.
+const DEFAULT_TUNE_TOLERANCE_CENTS float64 = 25
+
+// Farthest from a note a frequency is still that note, played out of tune,
+// half the way to the next semitone.
+const NOTE_MAX_CENTS float64 = 50
+
+// Farthest from a note a clear pitch is scored as the note badly out of tune,
+// a miss, up to the next semitone.
+const NOTE_BAD_MAX_CENTS float64 = 100
+
+// Status of a frequency matched to a note.
+const (
+	NOTE_IN_TUNE int = iota
+	NOTE_SHARP
+	NOTE_FLAT
+	NOTE_UNKNOWN
+)
+
+// Names of the statuses, in the order of the constants.
+var noteStatusNames = []string{"in tune", "sharp", "flat", "unknown"}
+
+// Semitones from C5 to A4, the reference.
+const C5_FROM_A4_SEMITONES int = 3
+
+// Tuning of the notes, set from the command line and the configuration before MNnew.
+var tuningA4Frequency float64 = DEFAULT_A4_FREQUENCY
+var tuningInstrument string = DEFAULT_INSTRUMENT
+var tuningToleranceCents float64 = DEFAULT_TUNE_TOLERANCE_CENTS
+
+// Names of the instruments, in alphabetical order.
+func instrumentNames() []string {
+	names := []string{}
+	for name := range instrumentTranspositions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Checks the reference pitch, the instrument and the tolerance given in the command line or in the configuration.
+func validateTuning(a4Frequency float64, instrument string, toleranceCents float64) error {
+	if a4Frequency < MIN_A4_FREQUENCY || a4Frequency > MAX_A4_FREQUENCY {
+		return fmt.Errorf("the reference A4 must be between %g and %g Hz, it is %g", MIN_A4_FREQUENCY, MAX_A4_FREQUENCY, a4Frequency)
+	}
+	if _, ok := instrumentTranspositions[strings.ToLower(instrument)]; !ok {
+		return fmt.Errorf("there is no instrument %q, it can be %s", instrument, strings.Join(instrumentNames(), ", "))
+	}
+	if toleranceCents <= 0 || toleranceCents > NOTE_MAX_CENTS {
+		return fmt.Errorf("the tolerance must be more than 0 and at most %g cents, it is %g", NOTE_MAX_CENTS, toleranceCents)
+	}
+	return nil
+}
+
+// Sets the frequency of each note from its semitones above DO, in equal
+// temperament from the reference A4, moved by the transposition of the instrument.
+func (MN *MusicNote) MNTune(a4Frequency float64, instrument string) {
+	transposition := instrumentTranspositions[strings.ToLower(instrument)]
+	for i := 0; i < fluteNoteLen; i++ {
+		semitonesFromA4 := C5_FROM_A4_SEMITONES + transposition + MN.semitones[i]
+		MN.frequency[i] = a4Frequency * math.Pow(2, float64(semitonesFromA4)/12)
+	}
+}
+
+// Note matched to a frequency.
+type NoteMatch struct {
+	note   int     // Nearest flute note, -1 without a frequency.
+	cents  float64 // Cents of the frequency above the note, negative when below.
+	status int     // NOTE_IN_TUNE, NOTE_SHARP, NOTE_FLAT or NOTE_UNKNOWN.
+}
+
+// Matches a frequency to the nearest note of the flute.
+func (MN *MusicNote) MNMatchNote(frequency float64) NoteMatch {
+	note := MN.MNFindFluteNoteIndex(frequency)
+	cents, status := MN.MNNoteStatus(note, frequency)
+	return NoteMatch{note: note, cents: cents, status: status}
+}
+
+// Cents of a frequency from a note, and if it's in tune, sharp, flat or too
+// far to be the note. NOTE_UNKNOWN without a note or a frequency.
+func (MN *MusicNote) MNNoteStatus(note int, frequency float64) (cents float64, status int) {
+	if note < 0 || frequency <= 0 {
+		return 0, NOTE_UNKNOWN
+	}
+	cents = centsBetween(frequency, MN.frequency[note])
+	switch {
+	case math.Abs(cents) > NOTE_MAX_CENTS:
+		return cents, NOTE_UNKNOWN
+	case cents > MN.tolerance[note]:
+		return cents, NOTE_SHARP
+	case cents < -MN.tolerance[note]:
+		return cents, NOTE_FLAT
+	}
+	return cents, NOTE_IN_TUNE
+}
@@ -0,0 +1,127 @@
+// Tests of the tuning and of the status of the notes played.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMNTune(t *testing.T) {
+	tests := []struct {
+		a4Frequency float64
+		instrument  string
+		note        int
+		want        float64
+	}{
+		{440, "soprano", DO, 523.25},
+		{440, "soprano", LA, 880},
+		{440, "soprano", EMPTY, 1174.66},
+		{415, "soprano", LA, 830},
+		{442, "Soprano", LA, 884},
+		{440, "alto", DO, 349.23},
+		{440, "tenor", DO, 261.63},
+		{440, "sopranino", DO, 698.46},
+	}
+	for _, test := range tests {
+		MN := MusicNote{}
+		MN.MNnew()
+		MN.MNTune(test.a4Frequency, test.instrument)
+		if got := MN.frequency[test.note]; math.Abs(got-test.want) > 0.01 {
+			t.Errorf("%s at %g Hz: %s is %.2f Hz, want %.2f Hz", test.instrument, test.a4Frequency, fluteNoteNames[test.note], got, test.want)
+		}
+	}
+}
+
+func TestMNNoteStatus(t *testing.T) {
+	musicNote.MNnew()
+	la := musicNote.frequency[LA]
+	tests := []struct {
+		name      string
+		note      int
+		frequency float64
+		tolerance float64 // Of LA, 0 for the default.
+		want      int
+	}{
+		{"on the note", LA, la, 0, NOTE_IN_TUNE},
+		{"inside the tolerance above", LA, la * math.Pow(2, 24.9/1200), 0, NOTE_IN_TUNE},
+		{"inside the tolerance below", LA, la * math.Pow(2, -24.9/1200), 0, NOTE_IN_TUNE},
+		{"sharp past the tolerance", LA, la * math.Pow(2, 25.1/1200), 0, NOTE_SHARP},
+		{"flat past the tolerance", LA, la * math.Pow(2, -25.1/1200), 0, NOTE_FLAT},
+		{"sharp inside 50 cents", LA, la * math.Pow(2, 49.9/1200), 0, NOTE_SHARP},
+		{"flat inside 50 cents", LA, la * math.Pow(2, -49.9/1200), 0, NOTE_FLAT},
+		{"unknown past 50 cents above", LA, la * math.Pow(2, 50.1/1200), 0, NOTE_UNKNOWN},
+		{"unknown past 50 cents below", LA, la * math.Pow(2, -50.1/1200), 0, NOTE_UNKNOWN},
+		{"inside the tolerance of a profile", LA, la * math.Pow(2, 39.9/1200), 40, NOTE_IN_TUNE},
+		{"past the tolerance of a profile", LA, la * math.Pow(2, -40.1/1200), 40, NOTE_FLAT},
+		{"no frequency", LA, 0, 0, NOTE_UNKNOWN},
+		{"no pitch", LA, -1, 0, NOTE_UNKNOWN},
+		{"silence", SILENCE, la, 0, NOTE_UNKNOWN},
+		{"uncertain", UNCERTAIN, la, 0, NOTE_UNKNOWN},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			musicNote.MNnew()
+			defer musicNote.MNnew()
+			if test.tolerance > 0 {
+				musicNote.tolerance[LA] = test.tolerance
+			}
+			cents, status := musicNote.MNNoteStatus(test.note, test.frequency)
+			if status != test.want {
+				t.Errorf("status %s at %+.1f cents, want %s", noteStatusNames[status], cents, noteStatusNames[test.want])
+			}
+			if test.note >= 0 && test.frequency > 0 {
+				if want := centsBetween(test.frequency, la); math.Abs(cents-want) > 1e-9 {
+					t.Errorf("%g cents, want %g", cents, want)
+				}
+			} else if cents != 0 {
+				t.Errorf("%g cents without a note or a frequency, want 0", cents)
+			}
+		})
+	}
+}
+
+func TestMNMatchNote(t *testing.T) {
+	musicNote.MNnew()
+	tests := []struct {
+		frequency  float64
+		wantNote   int
+		wantStatus int
+	}{
+		{musicNote.frequency[SOL], SOL, NOTE_IN_TUNE},
+		{musicNote.frequency[MI] * math.Pow(2, 40.0/1200), MI, NOTE_SHARP},
+		{musicNote.frequency[MI] * math.Pow(2, 60.0/1200), FA, NOTE_FLAT},
+		{musicNote.frequency[DO] * math.Pow(2, -70.0/1200), DO, NOTE_UNKNOWN},
+		{musicNote.frequency[RE] * math.Pow(2, 90.0/1200), RE, NOTE_UNKNOWN},
+		{0, -1, NOTE_UNKNOWN},
+	}
+	for _, test := range tests {
+		if match := musicNote.MNMatchNote(test.frequency); match.note != test.wantNote || match.status != test.wantStatus {
+			t.Errorf("MNMatchNote(%g) = %d %s, want %d %s", test.frequency, match.note, noteStatusNames[match.status], test.wantNote, noteStatusNames[test.wantStatus])
+		}
+	}
+}
+
+func TestValidateTuning(t *testing.T) {
+	tests := []struct {
+		a4Frequency    float64
+		instrument     string
+		toleranceCents float64
+		valid          bool
+	}{
+		{440, "soprano", 25, true},
+		{415, "ALTO", 50, true},
+		{MIN_A4_FREQUENCY, "tenor", 1, true},
+		{MAX_A4_FREQUENCY, "sopranino", 25, true},
+		{MIN_A4_FREQUENCY - 1, "soprano", 25, false},
+		{MAX_A4_FREQUENCY + 1, "soprano", 25, false},
+		{440, "bass", 25, false},
+		{440, "soprano", 0, false},
+		{440, "soprano", NOTE_MAX_CENTS + 1, false},
+	}
+	for _, test := range tests {
+		if err := validateTuning(test.a4Frequency, test.instrument, test.toleranceCents); (err == nil) != test.valid {
+			t.Errorf("validateTuning(%g, %q, %g) = %v, want valid %v", test.a4Frequency, test.instrument, test.toleranceCents, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// WAV file decoding and encoding.
+//
+// Reads RIFF/WAVE files with PCM samples of 8, 16, 24 or 32 bits and
+// IEEE float samples of 32 or 64 bits, at any sample rate and with any
+// number of channels. The samples are returned as interleaved float32
+// values in the range [-1.0, 1.0], the same format that portaudio
+// delivers to processAudio.
+//
+// Writes 32 bit IEEE float WAV files, so a recording keeps the samples
+// exactly as the game analysed them.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+const (
+	WAV_FORMAT_PCM        int = 1
+	WAV_FORMAT_IEEE_FLOAT int = 3
+	WAV_FORMAT_EXTENSIBLE int = 0xFFFE
+)
+
+type WavInfo struct {
+	Format        int // WAV_FORMAT_PCM or WAV_FORMAT_IEEE_FLOAT.
+	Channels      int // Number of interleaved channels.
+	SampleRate    int // Samples per second of each channel.
+	BitsPerSample int // 8, 16, 24, 32 or 64.
+}
+
+// Reads a WAV file and returns its interleaved samples.
+func readWavFile(wavFilePathAndName string) (samples []float32, info WavInfo, err error) {
+	raw, err := ioutil.ReadFile(wavFilePathAndName)
+	if err != nil {
+		return nil, info, err
+	}
+	return decodeWav(raw)
+}
+
+func decodeWav(raw []byte) (samples []float32, info WavInfo, err error) {
+	if len(raw) < 12 || string(raw[0:4]) != "RIFF" || string(raw[8:12]) != "WAVE" {
+		return nil, info, errors.New("not a RIFF/WAVE file")
+	}
+
+	var data []byte
+	foundFormat := false
+	pos := 12
+	for pos+8 <= len(raw) {
+		chunkId := string(raw[pos : pos+4])
+		// The size is kept in an int64, a size of 2 GiB or more would be
+		// negative in the int of a 32 bit build.
+		chunkSize := int64(binary.LittleEndian.Uint32(raw[pos+4 : pos+8]))
+		chunkStart := pos + 8
+		chunkEnd := len(raw)
+		if int64(chunkStart)+chunkSize < int64(len(raw)) {
+			chunkEnd = chunkStart + int(chunkSize)
+		}
+		// Else a truncated file, or a streaming writer that never filled the size in.
+
+		switch chunkId {
+		case "fmt ":
+			if chunkEnd-chunkStart < 16 {
+				return nil, info, errors.New("WAV fmt chunk is too short")
+			}
+			fmtChunk := raw[chunkStart:chunkEnd]
+			info.Format = int(binary.LittleEndian.Uint16(fmtChunk[0:2]))
+			info.Channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			info.BitsPerSample = int(binary.LittleEndian.Uint16(fmtChunk[14:16]))
+			if info.Format == WAV_FORMAT_EXTENSIBLE {
+				if len(fmtChunk) < 26 {
+					return nil, info, errors.New("WAV extensible fmt chunk is too short")
+				}
+				// The first two bytes of the sub format GUID are the real format code.
+				info.Format = int(binary.LittleEndian.Uint16(fmtChunk[24:26]))
+			}
+			foundFormat = true
+
+		case "data":
+			data = raw[chunkStart:chunkEnd]
+		}
+
+		// Chunks are padded to an even number of bytes.
+		next := int64(chunkStart) + chunkSize + chunkSize%2
+		if next >= int64(len(raw)) {
+			break
+		}
+		pos = int(next)
+	}
+
+	if !foundFormat {
+		return nil, info, errors.New("WAV file has no fmt chunk")
+	}
+	if data == nil {
+		return nil, info, errors.New("WAV file has no data chunk")
+	}
+	if info.Channels < 1 || info.SampleRate < 1 {
+		return nil, info, fmt.Errorf("invalid WAV format: %d channels at %d Hz", info.Channels, info.SampleRate)
+	}
+
+	bytesPerSample := info.BitsPerSample / 8
+	if bytesPerSample < 1 {
+		return nil, info, fmt.Errorf("unsupported WAV sample size of %d bits", info.BitsPerSample)
+	}
+	numSamples := len(data) / bytesPerSample
+	// Drop an incomplete last frame.
+	numSamples -= numSamples % info.Channels
+	samples = make([]float32, numSamples)
+
+	switch {
+	case info.Format == WAV_FORMAT_PCM && info.BitsPerSample == 8:
+		// 8 bit PCM is unsigned.
+		for i := range samples {
+			samples[i] = (float32(data[i]) - 128) / 128
+		}
+	case info.Format == WAV_FORMAT_PCM && info.BitsPerSample == 16:
+		for i := range samples {
+			samples[i] = float32(int16(binary.LittleEndian.Uint16(data[2*i:]))) / 32768
+		}
+	case info.Format == WAV_FORMAT_PCM && info.BitsPerSample == 24:
+		for i := range samples {
+			b := data[3*i : 3*i+3]
+			value := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			samples[i] = float32(value) / 8388608
+		}
+	case info.Format == WAV_FORMAT_PCM && info.BitsPerSample == 32:
+		for i := range samples {
+			samples[i] = float32(float64(int32(binary.LittleEndian.Uint32(data[4*i:]))) / 2147483648)
+		}
+	case info.Format == WAV_FORMAT_IEEE_FLOAT && info.BitsPerSample == 32:
+		for i := range samples {
+			samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+		}
+	case info.Format == WAV_FORMAT_IEEE_FLOAT && info.BitsPerSample == 64:
+		for i := range samples {
+			samples[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:])))
+		}
+	default:
+		return nil, info, fmt.Errorf("unsupported WAV format %d with %d bits per sample", info.Format, info.BitsPerSample)
+	}
+
+	return samples, info, nil
+}
+
+//##################
+// WAV file writing.
+
+// Size of the RIFF header, the fmt chunk and the data chunk header.
+const WAV_HEADER_LEN int = 12 + 8 + 16 + 8
+
+type wavFileWriter struct {
+	file        *os.File
+	writer      *bufio.Writer
+	channels    int
+	sampleRate  int
+	dataLen     int64 // Bytes of samples written so far.
+	sampleBytes []byte
+}
+
+// Creates the file and writes a header, the sizes in it are only right after Close.
+func createWavFile(wavFilePathAndName string, sampleRate int, channels int) (*wavFileWriter, error) {
+	file, err := os.Create(wavFilePathAndName)
+	if err != nil {
+		return nil, err
+	}
+	W := &wavFileWriter{
+		file:        file,
+		writer:      bufio.NewWriter(file),
+		channels:    channels,
+		sampleRate:  sampleRate,
+		sampleBytes: make([]byte, 4),
+	}
+	// Until Close, the sizes say "until the end of the file", decodeWav reads that.
+	if _, err := W.writer.Write(W.header(0xFFFFFFFF - int64(WAV_HEADER_LEN))); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return W, nil
+}
+
+func (W *wavFileWriter) header(dataLen int64) []byte {
+	header := make([]byte, WAV_HEADER_LEN)
+	blockAlign := 4 * W.channels
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(int64(WAV_HEADER_LEN-8)+dataLen))
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], uint16(WAV_FORMAT_IEEE_FLOAT))
+	binary.LittleEndian.PutUint16(header[22:24], uint16(W.channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(W.sampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(W.sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:36], 32)
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(dataLen))
+	return header
+}
+
+// Writes interleaved samples.
+func (W *wavFileWriter) Write(samples []float32) error {
+	for _, sample := range samples {
+		binary.LittleEndian.PutUint32(W.sampleBytes, math.Float32bits(sample))
+		if _, err := W.writer.Write(W.sampleBytes); err != nil {
+			return err
+		}
+	}
+	W.dataLen += int64(4 * len(samples))
+	return nil
+}
+
+// Writes the real sizes in the header and closes the file.
+func (W *wavFileWriter) Close() error {
+	err := W.writer.Flush()
+	if err == nil {
+		_, err = W.file.WriteAt(W.header(W.dataLen), 0)
+	}
+	if closeErr := W.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
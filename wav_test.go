@@ -0,0 +1,137 @@
+// Tests of the WAV decoding.
+
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// Builds a WAV file with a fmt chunk of fmtLen bytes, 16, 18 or 40 for the
+// extensible format, and a data chunk whose header says dataSize bytes.
+func buildWav(format int, channels int, bitsPerSample int, fmtLen int, data []byte, dataSize uint32) []byte {
+	fmtChunk := make([]byte, 40)
+	binary.LittleEndian.PutUint16(fmtChunk[0:], uint16(format))
+	binary.LittleEndian.PutUint16(fmtChunk[2:], uint16(channels))
+	binary.LittleEndian.PutUint32(fmtChunk[4:], 44100)
+	binary.LittleEndian.PutUint32(fmtChunk[8:], uint32(44100*channels*bitsPerSample/8))
+	binary.LittleEndian.PutUint16(fmtChunk[12:], uint16(channels*bitsPerSample/8))
+	binary.LittleEndian.PutUint16(fmtChunk[14:], uint16(bitsPerSample))
+	if format == WAV_FORMAT_EXTENSIBLE {
+		binary.LittleEndian.PutUint16(fmtChunk[16:], 22)
+		binary.LittleEndian.PutUint16(fmtChunk[24:], uint16(WAV_FORMAT_PCM))
+	}
+
+	fmtChunk = fmtChunk[:fmtLen]
+
+	raw := []byte("RIFF\x00\x00\x00\x00WAVE")
+	raw = append(raw, chunk("fmt ", fmtChunk, uint32(len(fmtChunk)))...)
+	raw = append(raw, chunk("data", data, dataSize)...)
+	binary.LittleEndian.PutUint32(raw[4:], uint32(len(raw)-8))
+	return raw
+}
+
+func chunk(id string, body []byte, size uint32) []byte {
+	raw := append([]byte(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(raw[4:], size)
+	return append(raw, body...)
+}
+
+func le16(values ...int16) []byte {
+	raw := make([]byte, 2*len(values))
+	for i, value := range values {
+		binary.LittleEndian.PutUint16(raw[2*i:], uint16(value))
+	}
+	return raw
+}
+
+func le32(values ...uint32) []byte {
+	raw := make([]byte, 4*len(values))
+	for i, value := range values {
+		binary.LittleEndian.PutUint32(raw[4*i:], value)
+	}
+	return raw
+}
+
+func le64(values ...float64) []byte {
+	raw := make([]byte, 8*len(values))
+	for i, value := range values {
+		binary.LittleEndian.PutUint64(raw[8*i:], math.Float64bits(value))
+	}
+	return raw
+}
+
+func TestDecodeWav(t *testing.T) {
+	minInt32 := int32(math.MinInt32)
+	tests := []struct {
+		name     string
+		raw      []byte
+		channels int
+		want     []float32
+	}{
+		{"8 bit", buildWav(WAV_FORMAT_PCM, 1, 8, 16, []byte{0, 128, 192}, 3), 1, []float32{-1, 0, 0.5}},
+		{"16 bit", buildWav(WAV_FORMAT_PCM, 1, 16, 16, le16(-32768, 0, 16384), 6), 1, []float32{-1, 0, 0.5}},
+		{"24 bit", buildWav(WAV_FORMAT_PCM, 1, 24, 16, []byte{0, 0, 0x80, 0, 0, 0, 0, 0, 0x40}, 9), 1, []float32{-1, 0, 0.5}},
+		{"32 bit", buildWav(WAV_FORMAT_PCM, 1, 32, 16, le32(uint32(minInt32), 0, 1<<30), 12), 1, []float32{-1, 0, 0.5}},
+		{"float 32 bit", buildWav(WAV_FORMAT_IEEE_FLOAT, 1, 32, 16, le32(math.Float32bits(-0.5), math.Float32bits(0.25)), 8), 1, []float32{-0.5, 0.25}},
+		{"float 64 bit", buildWav(WAV_FORMAT_IEEE_FLOAT, 1, 64, 18, le64(-0.5, 0.25), 16), 1, []float32{-0.5, 0.25}},
+		{"extensible", buildWav(WAV_FORMAT_EXTENSIBLE, 1, 16, 40, le16(16384, -16384), 4), 1, []float32{0.5, -0.5}},
+		{"stereo", buildWav(WAV_FORMAT_PCM, 2, 16, 16, le16(16384, -16384), 4), 2, []float32{0.5, -0.5}},
+		{"incomplete last frame", buildWav(WAV_FORMAT_PCM, 2, 16, 16, le16(16384, -16384, 0), 6), 2, []float32{0.5, -0.5}},
+		{"truncated data chunk", buildWav(WAV_FORMAT_PCM, 1, 16, 16, le16(16384, 0), 1000), 1, []float32{0.5, 0}},
+		{"truncated sample", buildWav(WAV_FORMAT_PCM, 1, 16, 16, []byte{0, 0x40, 0}, 3), 1, []float32{0.5}},
+		{"size never filled in", buildWav(WAV_FORMAT_IEEE_FLOAT, 1, 32, 16, le32(math.Float32bits(0.25)), 0xFFFFFFFF), 1, []float32{0.25}},
+		{"data chunk of 2 GiB", buildWav(WAV_FORMAT_PCM, 1, 16, 16, le16(16384), 0x80000001), 1, []float32{0.5}},
+		{"chunk of 2 GiB after the data", append(buildWav(WAV_FORMAT_PCM, 1, 16, 16, le16(16384), 2), chunk("LIST", []byte("INFO"), 0x80000000)...), 1, []float32{0.5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			samples, info, err := decodeWav(test.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Channels != test.channels || info.SampleRate != 44100 {
+				t.Errorf("%d channels at %d Hz, want %d at 44100 Hz", info.Channels, info.SampleRate, test.channels)
+			}
+			if len(samples) != len(test.want) {
+				t.Fatalf("samples %v, want %v", samples, test.want)
+			}
+			for i := range samples {
+				if math.Abs(float64(samples[i]-test.want[i])) > 1e-6 {
+					t.Errorf("samples %v, want %v", samples, test.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeWavErrors(t *testing.T) {
+	noData := buildWav(WAV_FORMAT_PCM, 1, 16, 16, nil, 0)[:12+8+16]
+	binary.LittleEndian.PutUint32(noData[4:], uint32(len(noData)-8))
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"empty", []byte{}},
+		{"not RIFF", []byte("RIFX\x00\x00\x00\x00WAVE")},
+		{"no fmt chunk", append([]byte("RIFF\x0c\x00\x00\x00WAVE"), chunk("data", le16(0), 2)...)},
+		{"no data chunk", noData},
+		{"fmt chunk too short", buildWav(WAV_FORMAT_PCM, 1, 16, 14, le16(0), 2)},
+		{"fmt chunk of 4 GiB", append([]byte("RIFF\x00\x00\x00\x00WAVE"), chunk("fmt ", buildWav(WAV_FORMAT_PCM, 1, 16, 16, le16(0), 2)[20:36], 0xFFFFFFF0)...)},
+		{"chunk of 2 GiB before the data", append(append([]byte("RIFF\x00\x00\x00\x00WAVE"), chunk("LIST", []byte("INFO"), 0x80000000)...), buildWav(WAV_FORMAT_PCM, 1, 16, 16, le16(0), 2)[12:]...)},
+		{"extensible fmt chunk too short", buildWav(WAV_FORMAT_EXTENSIBLE, 1, 16, 18, le16(0), 2)},
+		{"no channels", buildWav(WAV_FORMAT_PCM, 0, 16, 16, le16(0), 2)},
+		{"12 bit", buildWav(WAV_FORMAT_PCM, 1, 12, 16, le16(0), 2)},
+		{"float 16 bit", buildWav(WAV_FORMAT_IEEE_FLOAT, 1, 16, 16, le16(0), 2)},
+		{"4 bit", buildWav(WAV_FORMAT_PCM, 1, 4, 16, []byte{0}, 1)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := decodeWav(test.raw); err == nil {
+				t.Errorf("no error")
+			}
+		})
+	}
+}
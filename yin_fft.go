@@ -0,0 +1,122 @@
+// YIN difference function with the FFT.
+//
+// The difference of the signal with itself shifted by tau expands into
+//
+//	d(tau) = sum x[i]^2 + sum x[i+tau]^2 - 2 * sum x[i] * x[i+tau]
+//
+// with every sum over the first halfBufferSize values of i. The first two are
+// energies, taken from a running sum of the squares, and the third is the
+// autocorrelation, found for all the values of tau at once with the FFT. The
+// result is the same as the direct loop of yinDifferenceDirect, with rounding
+// differences only, but in O(N log N) instead of O(N^2).
+
+package main
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// Radix 2 FFT of a fixed size, with its tables computed once.
+type fftPlan struct {
+	size       int
+	twiddle    []complex128 // exp(-2 pi i k / size), for k < size / 2.
+	bitReverse []int        // Position of each value before the butterflies.
+}
+
+// Creates a plan for the smallest power of two that fits minSize values.
+func newFFTPlan(minSize int) *fftPlan {
+	size := 1
+	for size < minSize {
+		size *= 2
+	}
+	P := &fftPlan{size: size, twiddle: make([]complex128, size/2), bitReverse: make([]int, size)}
+	for k := range P.twiddle {
+		P.twiddle[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(size)))
+	}
+	shift := 64 - bits.TrailingZeros(uint(size))
+	for i := range P.bitReverse {
+		P.bitReverse[i] = int(bits.Reverse64(uint64(i)) >> uint(shift))
+	}
+	return P
+}
+
+// Transforms data in place, len(data) must be the size of the plan. The
+// inverse isn't divided by the size.
+func (P *fftPlan) Transform(data []complex128, inverse bool) {
+	for i, j := range P.bitReverse {
+		if i < j {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+	for half := 1; half < P.size; half *= 2 {
+		step := P.size / (2 * half)
+		for start := 0; start < P.size; start += 2 * half {
+			for k := 0; k < half; k++ {
+				w := P.twiddle[k*step]
+				if inverse {
+					w = cmplx.Conj(w)
+				}
+				a := data[start+k]
+				b := data[start+k+half] * w
+				data[start+k] = a + b
+				data[start+k+half] = a - b
+			}
+		}
+	}
+}
+
+// Step 1 with the FFT, same result as yinDifferenceDirect.
+func (Y *Yin) yinDifferenceFFT(buffer []float32) {
+	if Y.fft == nil || Y.fft.size < Y.bufferSize {
+		// The autocorrelation never wraps around, i + tau < bufferSize.
+		Y.fft = newFFTPlan(Y.bufferSize)
+		Y.fftData = make([]complex128, Y.fft.size)
+		Y.energy = make([]float64, Y.bufferSize+1)
+	}
+	n := Y.fft.size
+	data := Y.fftData
+
+	// Both real FFTs in one, the whole buffer in the real part and its first
+	// half in the imaginary part.
+	for i := range data {
+		re, im := 0.0, 0.0
+		if i < Y.bufferSize {
+			re = float64(buffer[i])
+		}
+		if i < Y.halfBufferSize {
+			im = re
+		}
+		data[i] = complex(re, im)
+	}
+	Y.fft.Transform(data, false)
+
+	// Separates the two spectrums and multiplies them, conj(FIRST_HALF) * WHOLE,
+	// the spectrum of the correlation. Goes through the pairs k and n - k together.
+	for k := 0; k <= n/2; k++ {
+		zk := data[k]
+		zn := cmplx.Conj(data[(n-k)%n])
+		whole := (zk + zn) / 2
+		firstHalf := (zk - zn) / complex(0, 2)
+		data[k] = cmplx.Conj(firstHalf) * whole
+		if k > 0 && k < n-k {
+			data[n-k] = cmplx.Conj(data[k])
+		}
+	}
+	Y.fft.Transform(data, true)
+
+	// Running sum of the squares, for the energy of any part of the buffer.
+	Y.energy[0] = 0
+	for i := 0; i < Y.bufferSize; i++ {
+		Y.energy[i+1] = Y.energy[i] + float64(buffer[i])*float64(buffer[i])
+	}
+
+	firstEnergy := Y.energy[Y.halfBufferSize]
+	for tau := 0; tau < Y.tauEnd; tau++ {
+		shiftedEnergy := Y.energy[tau+Y.halfBufferSize] - Y.energy[tau]
+		correlation := real(data[tau]) / float64(n)
+		// Rounding can leave a tiny negative value where the difference is zero.
+		Y.yinBuffer[tau] = math.Max(0, firstEnergy+shiftedEnergy-2*correlation)
+	}
+}
@@ -0,0 +1,89 @@
+// Tests and benchmarks of the YIN difference with the FFT, against the
+// direct loop, on a recorder like sound:
+//
+//	go test -run Yin -bench Yin
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Window lengths of the benchmarks, 5000 is the window of the first versions of the game.
+var benchYinWindowLens = []int{512, 1024, 2048, 4096, 5000}
+
+// Window of a recorder like sound at a frequency, with harmonics and noise.
+func benchYinWindow(windowLen int, sampleRate float64, frequency float64) []float32 {
+	random := rand.New(rand.NewSource(1))
+	window := make([]float32, windowLen)
+	for i := range window {
+		t := float64(i) / sampleRate
+		value := 0.0
+		for h, amplitude := range recorderHarmonics {
+			value += amplitude * math.Sin(2*math.Pi*float64(h+1)*frequency*t)
+		}
+		window[i] = float32(SYNTH_AMPLITUDE*value + SYNTH_BREATH_NOISE*(random.Float64()*2-1))
+	}
+	return window
+}
+
+func TestYinFFTMatchesDirect(t *testing.T) {
+	sampleRate := float64(YIN_SAMPLING_RATE)
+	tests := []struct {
+		windowLen int
+		frequency float64
+	}{
+		{512, 1046.50},
+		{1024, 523.25},
+		{2048, 783.99},
+		{4096, 587.33},
+		{5000, 783.99},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d/%g", test.windowLen, test.frequency), func(t *testing.T) {
+			window := benchYinWindow(test.windowLen, sampleRate, test.frequency)
+			direct := newPitchYin(test.windowLen, sampleRate)
+			direct.direct = true
+			fast := newPitchYin(test.windowLen, sampleRate)
+
+			directPitch := direct.YinGetPitch(window)
+			fftPitch := fast.YinGetPitch(window)
+			if math.Abs(directPitch-fftPitch) > 1e-6*directPitch {
+				t.Errorf("pitch direct %g Hz, FFT %g Hz", directPitch, fftPitch)
+			}
+			if math.Abs(centsBetween(fftPitch, test.frequency)) > 10 {
+				t.Errorf("pitch %g Hz, want %g Hz", fftPitch, test.frequency)
+			}
+			for tau := range direct.yinBuffer {
+				if delta := math.Abs(direct.yinBuffer[tau] - fast.yinBuffer[tau]); delta > 1e-6 {
+					t.Fatalf("yinBuffer[%d] direct %g, FFT %g", tau, direct.yinBuffer[tau], fast.yinBuffer[tau])
+				}
+			}
+		})
+	}
+}
+
+func benchmarkYin(b *testing.B, direct bool) {
+	sampleRate := float64(YIN_SAMPLING_RATE)
+	for _, windowLen := range benchYinWindowLens {
+		window := benchYinWindow(windowLen, sampleRate, 783.99)
+		yin := newPitchYin(windowLen, sampleRate)
+		yin.direct = direct
+		b.Run(fmt.Sprintf("%d", windowLen), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				yin.YinGetPitch(window)
+			}
+		})
+	}
+}
+
+func BenchmarkYinDirect(b *testing.B) {
+	benchmarkYin(b, true)
+}
+
+func BenchmarkYinFFT(b *testing.B) {
+	benchmarkYin(b, false)
+}